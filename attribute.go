@@ -0,0 +1,16 @@
+package ecs_state
+
+// Local representation of an attribute of an ECS ContainerInstance and stored by gorm, such as the built in
+// ecs.instance-type or ecs.availability-zone, or a custom attribute.  Attributes without a value, such as
+// capabilities, have an empty Value.
+type Attribute struct {
+	ID                   int    `gorm:"primary_key"`
+	ContainerInstanceARN string `sql:"size:1024;index"`
+	Name                 string `sql:"size:1024;index"`
+	Value                string `sql:"size:1024"`
+}
+
+// The table Attributes are stored in, before any Options.TablePrefix.
+func (Attribute) TableName() string {
+	return "attributes"
+}
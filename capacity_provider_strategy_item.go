@@ -0,0 +1,15 @@
+package ecs_state
+
+// Local representation of one entry in an ECS cluster's default capacity provider strategy and stored by gorm.
+type CapacityProviderStrategyItem struct {
+	ID               int    `gorm:"primary_key"`
+	ClusterARN       string `sql:"size:1024;index"`
+	CapacityProvider string
+	Weight           int
+	Base             int
+}
+
+// The table CapacityProviderStrategyItems are stored in, before any Options.TablePrefix.
+func (CapacityProviderStrategyItem) TableName() string {
+	return "capacity_provider_strategy_items"
+}
@@ -0,0 +1,61 @@
+package ecs_state
+
+import "github.com/jinzhu/gorm"
+
+// The actions a StateChange may report.
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// A single row a refresh inserted, updated, or deleted, or a removal deleted, reported to Options.OnChange and logged
+// in DryRun mode.  Kind is the kind of entity changed, RefreshKindContainerInstances or RefreshKindTasks.  An update is
+// reported when a change DiffClusters would consider meaningful is made.
+type StateChange struct {
+	Kind   string
+	Action string
+	ARN    string
+}
+
+// Whether refreshes need to work out which rows they change.
+func (state *State) trackingChanges() bool {
+	return state.options.DryRun || state.options.OnChange != nil
+}
+
+// Applies a refresh to local state in a single transaction, so readers never see it half done.  Refreshes list and
+// describe everything from ECS before calling it, keeping the transaction short and leaving local state as it was when
+// a call to ECS fails.  The first error from apply rolls the transaction back and is returned, otherwise the
+// transaction is completed as commitChanges does and the changes apply made are returned for reportChanges.
+func (state *State) applyRefresh(apply func(tx *gorm.DB) ([]StateChange, error)) ([]StateChange, error) {
+	tx := state.DB().Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	changes, err := apply(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return changes, state.commitChanges(tx, changes)
+}
+
+// Completes the transaction of a refresh, committing it, or in DryRun mode logging its changes and rolling it back
+// instead.  Reporting the changes is left to the caller, as refreshes may hold a lock the OnChange hook needs.
+func (state *State) commitChanges(tx *gorm.DB, changes []StateChange) error {
+	if state.options.DryRun {
+		for _, change := range changes {
+			state.log.Info("Dry run would", change.Action, change.Kind, change.ARN)
+		}
+		tx.Rollback()
+		return nil
+	}
+	return tx.Commit().Error
+}
+
+// Reports the changes of a refresh or removal to any OnChange hook.
+func (state *State) reportChanges(changes []StateChange) {
+	if state.options.OnChange != nil && len(changes) > 0 {
+		state.options.OnChange(changes)
+	}
+}
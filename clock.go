@@ -0,0 +1,25 @@
+package ecs_state
+
+import "time"
+
+// The source of the current time for a State, used for refresh times, stale record removal, and every TTL.  Tests
+// may provide their own, through Options.Clock, to advance time deterministically.
+type Clock interface {
+	Now() time.Time
+}
+
+// The Clock used when none is configured, reporting the real time.
+type realClock struct{}
+
+// Returns the real current time.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// The current time according to the configured Clock.
+func (state *State) now() time.Time {
+	if state.options.Clock == nil {
+		return realClock{}.Now()
+	}
+	return state.options.Clock.Now()
+}
@@ -1,10 +1,23 @@
 package ecs_state
 
-// Local representation of an ECS cluster and stored by gorm
+// Local representation of an ECS cluster and stored by gorm.  CapacityProviders is a comma separated
+// list of the capacity providers registered with the cluster, and the counts are the headline statistics
+// reported by ECS at the time of the last refresh.
 type Cluster struct {
-	ARN                string `sql:"size:1024" gorm:"primary_key"`
-	Name               string `sql:"unique"`
-	Status             string
-	ContainerInstances []ContainerInstance
-	Tasks              []Task
+	ARN                               string `sql:"size:1024" gorm:"primary_key"`
+	Name                              string `sql:"unique"`
+	Status                            string
+	CapacityProviders                 string `sql:"size:1024"`
+	RunningTasksCount                 int
+	PendingTasksCount                 int
+	ActiveServicesCount               int
+	RegisteredContainerInstancesCount int
+	DefaultCapacityProviderStrategy   []CapacityProviderStrategyItem
+	ContainerInstances                []ContainerInstance
+	Tasks                             []Task
+}
+
+// The table Clusters are stored in, before any Options.TablePrefix.
+func (Cluster) TableName() string {
+	return "clusters"
 }
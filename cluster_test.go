@@ -0,0 +1,96 @@
+package ecs_state_test
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestClusterByNameOrARN(t *testing.T) {
+	for _, cluster := range []string{testClusterName, testClusterARN} {
+		t.Run(cluster, func(t *testing.T) {
+			client := mocks.NewECSAPI(t)
+			// ECS is asked about the cluster as it was given
+			client.On("DescribeClusters", mock.MatchedBy(func(params *ecs.DescribeClustersInput) bool {
+				return aws.StringValue(params.Clusters[0]) == cluster
+			})).Return(&ecs.DescribeClustersOutput{Clusters: []*ecs.Cluster{{
+				ClusterArn:  aws.String(testClusterARN),
+				ClusterName: aws.String(testClusterName),
+				Status:      aws.String("ACTIVE"),
+			}}}, nil).Once()
+			page := &ecs.ListContainerInstancesOutput{ContainerInstanceArns: []*string{containerInstance("a", 4096, 4096).ContainerInstanceArn}}
+			client.On("ListContainerInstancesPages", mock.MatchedBy(func(params *ecs.ListContainerInstancesInput) bool {
+				return aws.StringValue(params.Cluster) == cluster
+			}), mock.Anything).Run(func(args mock.Arguments) {
+				args.Get(1).(func(*ecs.ListContainerInstancesOutput, bool) bool)(page, true)
+			}).Return(nil)
+			client.On("DescribeContainerInstances", mock.Anything).Return(&ecs.DescribeContainerInstancesOutput{
+				ContainerInstances: []*ecs.ContainerInstance{containerInstance("a", 4096, 4096)},
+			}, nil)
+
+			state := ecs_state.InitializeWithOptions(cluster, client, ecs_state.Logger{Logger: log.New(io.Discard, "", 0)}, ecs_state.Options{})
+			t.Cleanup(func() { state.Close() })
+			// The cluster is refreshed first, as it is not yet in local state
+			if err := state.RefreshContainerInstanceState(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range []string{testClusterName, testClusterARN} {
+				found, err := state.FindClusterByNameE(name)
+				if err != nil {
+					t.Fatalf("FindClusterByNameE(%q): %v", name, err)
+				}
+				if found.ARN != testClusterARN || len(found.ContainerInstances) != 1 {
+					t.Errorf("FindClusterByNameE(%q) found %s with %d ContainerInstances, want %s with 1", name, found.ARN, len(found.ContainerInstances), testClusterARN)
+				}
+			}
+			containerInstances := []ecs_state.ContainerInstance{}
+			if err := state.DB().Find(&containerInstances).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(containerInstances) != 1 || containerInstances[0].ClusterARN != testClusterARN {
+				t.Errorf("stored %+v, want one ContainerInstance in %s", containerInstances, testClusterARN)
+			}
+		})
+	}
+}
+
+func TestRefreshClusterStateNilFields(t *testing.T) {
+	for name, test := range map[string]struct {
+		cluster *ecs.Cluster
+		stored  []ecs_state.Cluster
+	}{
+		"nil cluster": {nil, []ecs_state.Cluster{}},
+		"nil ARN":     {&ecs.Cluster{ClusterName: aws.String(testClusterName), Status: aws.String("ACTIVE")}, []ecs_state.Cluster{}},
+		"nil name":    {&ecs.Cluster{ClusterArn: aws.String(testClusterARN), Status: aws.String("ACTIVE")}, []ecs_state.Cluster{{ARN: testClusterARN, Status: "ACTIVE"}}},
+		"nil status":  {&ecs.Cluster{ClusterArn: aws.String(testClusterARN), ClusterName: aws.String(testClusterName)}, []ecs_state.Cluster{{ARN: testClusterARN, Name: testClusterName}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := mocks.NewECSAPI(t)
+			client.On("DescribeClusters", mock.Anything).Return(&ecs.DescribeClustersOutput{Clusters: []*ecs.Cluster{test.cluster}}, nil)
+			state := newTestState(t, client, ecs_state.Options{})
+			if err := state.RefreshClusterState(); err != nil {
+				t.Fatal(err)
+			}
+
+			clusters := []ecs_state.Cluster{}
+			if err := state.DB().Find(&clusters).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(clusters) != len(test.stored) {
+				t.Fatalf("stored %+v, want %+v", clusters, test.stored)
+			}
+			for i, cluster := range clusters {
+				if cluster.ARN != test.stored[i].ARN || cluster.Name != test.stored[i].Name || cluster.Status != test.stored[i].Status {
+					t.Errorf("stored %+v, want %+v", cluster, test.stored[i])
+				}
+			}
+		})
+	}
+}
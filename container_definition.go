@@ -0,0 +1,20 @@
+package ecs_state
+
+// Local representation of a single container within an ECS TaskDefinition and stored by gorm.  Only
+// the fields needed to reason about a container's share of the task's resources are kept.  The host ports the
+// container binds are comma separated, as for its TaskDefinition.
+type ContainerDefinition struct {
+	ID                int    `gorm:"primary_key"`
+	TaskDefinitionARN string `sql:"size:1024;index"`
+	Name              string
+	Essential         bool
+	Cpu               int
+	Memory            int
+	TCPPorts          string
+	UDPPorts          string
+}
+
+// The table ContainerDefinitions are stored in, before any Options.TablePrefix.
+func (ContainerDefinition) TableName() string {
+	return "container_definitions"
+}
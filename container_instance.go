@@ -1,28 +1,83 @@
 package ecs_state
 
+import "encoding/json"
+
 // Local representation of an ECS ContainerInstance and stored by gorm.
 // Notably, resources and other sub-objects have been placed into their own
-// columns for more robust query capabilities.
+// columns for more robust query capabilities.  CapacityProviderName is empty unless the instance
+// belongs to a capacity provider.  FreeDynamicTCPPorts and FreeDynamicUDPPorts count the ports of the
+// dynamic port range neither reserved as reported by ECS nor bound by a Task on the instance.  HealthStatus is the overall status of the instance health checks, such as
+// IMPAIRED, and is only reported when CONTAINER_INSTANCE_HEALTH is in Options.ContainerInstanceInclude.
 type ContainerInstance struct {
-	ARN                string `sql:"size:1024" gorm:"primary_key"`
-	AgentConnected     bool
-	AgentHash          string
-	AgentVersion       string
-	AgentUpdateStatus  string
-	ClusterARN         string `sql:"size:1024;index"`
-	DockerVersion      string
-	EC2InstanceId      string
-	RegisteredCPU      int    `gorm:"column:registered_cpu"`
-	RegisteredMemory   int    `gorm:"column:registered_memory"`
-	RegisteredTCPPorts string `sql:"size:1024" gorm:"column:registered_tcp_ports"`
-	RegisteredUDPPorts string `sql:"size:1024" gorm:"column:registered_udp_ports"`
-	RemainingCPU       int    `gorm:"column:remaining_cpu"`
-	RemainingMemory    int    `gorm:"column:remaining_memory"`
-	RemainingTCPPorts  string `sql:"size:1024" gorm:"column:remaining_tcp_ports"`
-	RemainingUDPPorts  string `sql:"size:1024" gorm:"column:remaining_udp_ports"`
-	Status             string
-	Tasks              []Task
+	ARN                  string `sql:"size:1024" gorm:"primary_key"`
+	AgentConnected       bool
+	AgentHash            string
+	AgentVersion         string
+	AgentUpdateStatus    string
+	CapacityProviderName string `sql:"index"`
+	ClusterARN           string `sql:"size:1024;index"`
+	DockerVersion        string
+	EC2InstanceId        string `sql:"index" gorm:"column:ec2_instance_id"`
+	HealthStatus         string `sql:"index"`
+	InstanceType         string `sql:"index"`
+	RegisteredCPU        int    `gorm:"column:registered_cpu"`
+	RegisteredMemory     int    `gorm:"column:registered_memory"`
+	RegisteredTCPPorts   string `sql:"size:1024" gorm:"column:registered_tcp_ports"`
+	RegisteredUDPPorts   string `sql:"size:1024" gorm:"column:registered_udp_ports"`
+	RemainingCPU         int    `gorm:"column:remaining_cpu"`
+	RemainingMemory      int    `gorm:"column:remaining_memory"`
+	RemainingTCPPorts    string `sql:"size:1024" gorm:"column:remaining_tcp_ports"`
+	RemainingUDPPorts    string `sql:"size:1024" gorm:"column:remaining_udp_ports"`
+	FreeDynamicTCPPorts  int    `gorm:"column:free_dynamic_tcp_ports"`
+	FreeDynamicUDPPorts  int    `gorm:"column:free_dynamic_udp_ports"`
+	Status               string
+	Tasks                []Task
 
 	// Not part of the ECS API
-	RefreshTime int
+	RefreshTime       int
+	DisconnectedSince int
+}
+
+// The table ContainerInstances are stored in, before any Options.TablePrefix.
+func (ContainerInstance) TableName() string {
+	return "container_instances"
+}
+
+// The JSON representation of a ContainerInstance, with ports rendered as lists of numbers instead of
+// the internal searchable string format.
+type containerInstanceJSON struct {
+	containerInstanceFields
+	RegisteredTCPPorts []int
+	RegisteredUDPPorts []int
+	RemainingTCPPorts  []int
+	RemainingUDPPorts  []int
+}
+
+// Shares the ContainerInstance fields without its JSON methods to avoid infinite recursion.
+type containerInstanceFields ContainerInstance
+
+// Renders the port columns as JSON arrays of port numbers.
+func (containerInstance ContainerInstance) MarshalJSON() ([]byte, error) {
+	return json.Marshal(containerInstanceJSON{
+		containerInstanceFields: containerInstanceFields(containerInstance),
+		RegisteredTCPPorts:      ParsePorts(containerInstance.RegisteredTCPPorts),
+		RegisteredUDPPorts:      ParsePorts(containerInstance.RegisteredUDPPorts),
+		RemainingTCPPorts:       ParsePorts(containerInstance.RemainingTCPPorts),
+		RemainingUDPPorts:       ParsePorts(containerInstance.RemainingUDPPorts),
+	})
+}
+
+// Reads the JSON produced by MarshalJSON, converting port arrays back to the internal searchable format.
+func (containerInstance *ContainerInstance) UnmarshalJSON(data []byte) error {
+	decoded := containerInstanceJSON{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*containerInstance = ContainerInstance(decoded.containerInstanceFields)
+	containerInstance.RegisteredTCPPorts = encodePorts(decoded.RegisteredTCPPorts)
+	containerInstance.RegisteredUDPPorts = encodePorts(decoded.RegisteredUDPPorts)
+	containerInstance.RemainingTCPPorts = encodePorts(decoded.RemainingTCPPorts)
+	containerInstance.RemainingUDPPorts = encodePorts(decoded.RemainingUDPPorts)
+	return nil
 }
@@ -0,0 +1,190 @@
+package ecs_state_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRemainingPortCount(t *testing.T) {
+	running := task("web", "web", "a")
+	running.Containers = []*ecs.Container{{NetworkBindings: []*ecs.NetworkBinding{
+		{HostPort: aws.Int64(50000), Protocol: aws.String(ecs.TransportProtocolTcp)},
+	}}}
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, withUDPPorts(containerInstance("a", 4096, 4096, "50002"), "50001"))
+	expectTasks(client, running)
+	state := newTestState(t, client, ecs_state.Options{DynamicPortRange: ecs_state.PortRange{Low: 50000, High: 50003}})
+	if err := state.RefreshAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	tcp, udp, err := state.RemainingPortCount("arn:aws:ecs:us-east-1:123456789012:container-instance/test/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tcp != 2 || udp != 3 {
+		t.Errorf("counted %d TCP and %d UDP ports free, want 2 and 3", tcp, udp)
+	}
+	if _, _, err := state.RemainingPortCount("arn:aws:ecs:us-east-1:123456789012:container-instance/test/missing"); err != ecs_state.ErrContainerInstanceNotFound {
+		t.Errorf("counted ports of a missing instance with error %v, want ErrContainerInstanceNotFound", err)
+	}
+}
+
+func TestRefreshContainerInstanceStateBeforeCluster(t *testing.T) {
+	for name, describeErr := range map[string]error{"cluster refreshed first": nil, "cluster unavailable": errors.New("throttled")} {
+		t.Run(name, func(t *testing.T) {
+			client := mocks.NewECSAPI(t)
+			if describeErr != nil {
+				client.On("DescribeClusters", mock.Anything).Return(nil, describeErr)
+			}
+			expectContainerInstances(client, containerInstance("a", 4096, 4096))
+			state := newTestState(t, client, ecs_state.Options{})
+			if err := state.RefreshContainerInstanceState(); err != nil {
+				t.Fatal(err)
+			}
+
+			containerInstances := []ecs_state.ContainerInstance{}
+			if err := state.DB().Find(&containerInstances).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(containerInstances) != 1 || containerInstances[0].ClusterARN != testClusterARN {
+				t.Errorf("stored %+v, want the instance with ClusterARN %s", containerInstances, testClusterARN)
+			}
+		})
+	}
+}
+
+func TestRefreshContainerInstanceStateSkipsMissingARN(t *testing.T) {
+	missing := containerInstance("missing", 4096, 4096)
+	missing.ContainerInstanceArn = nil
+	missing.Status = nil
+
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 4096, 4096), missing)
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+
+	containerInstances := []ecs_state.ContainerInstance{}
+	if err := state.DB().Find(&containerInstances).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(containerInstances) != 1 || containerInstances[0].EC2InstanceId != "i-a" {
+		t.Errorf("stored %+v, want only i-a", containerInstances)
+	}
+}
+
+func TestRefreshContainerInstanceStateMissingResources(t *testing.T) {
+	for fallback, want := range map[ecs_state.ResourceFallback]int{
+		ecs_state.ResourceFallbackZero:       0,
+		ecs_state.ResourceFallbackRegistered: 4096,
+		ecs_state.ResourceFallbackLastKnown:  1024,
+	} {
+		reported := containerInstance("a", 1024, 1024)
+		omitted := containerInstance("a", 1024, 1024)
+		omitted.RemainingResources = nil
+
+		client := mocks.NewECSAPI(t)
+		expectCluster(client)
+		for _, containerInstance := range []*ecs.ContainerInstance{reported, omitted} {
+			page := &ecs.ListContainerInstancesOutput{ContainerInstanceArns: []*string{containerInstance.ContainerInstanceArn}}
+			client.On("ListContainerInstancesPages", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				args.Get(1).(func(*ecs.ListContainerInstancesOutput, bool) bool)(page, true)
+			}).Return(nil).Once()
+			client.On("DescribeContainerInstances", mock.Anything).Return(&ecs.DescribeContainerInstancesOutput{ContainerInstances: []*ecs.ContainerInstance{containerInstance}}, nil).Once()
+		}
+		state := newTestState(t, client, ecs_state.Options{MissingResources: fallback})
+		for i := 0; i < 2; i++ {
+			if err := state.RefreshContainerInstanceState(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stored := ecs_state.ContainerInstance{}
+		if err := state.DB().Where("a_r_n = ?", *reported.ContainerInstanceArn).First(&stored).Error; err != nil {
+			t.Fatal(err)
+		}
+		if stored.RemainingCPU != want || stored.RemainingMemory != want {
+			t.Errorf("fallback %d kept %d CPU and %d memory remaining, want %d of each", fallback, stored.RemainingCPU, stored.RemainingMemory, want)
+		}
+	}
+}
+
+func TestRefreshContainerInstanceStateNilResources(t *testing.T) {
+	for name, test := range map[string]struct {
+		edit           func(*ecs.ContainerInstance)
+		cpu            int
+		remainingPorts string
+	}{
+		"nil resource":          {func(c *ecs.ContainerInstance) { c.RemainingResources = append(c.RemainingResources, nil) }, 1024, "=22==80="},
+		"nil name":              {func(c *ecs.ContainerInstance) { c.RemainingResources[0].Name = nil }, 0, "=22==80="},
+		"nil type":              {func(c *ecs.ContainerInstance) { c.RemainingResources[0].Type = nil }, 0, "=22==80="},
+		"nil integer value":     {func(c *ecs.ContainerInstance) { c.RemainingResources[0].IntegerValue = nil }, 0, "=22==80="},
+		"nil port":              {func(c *ecs.ContainerInstance) { c.RemainingResources[2].StringSetValue[1] = nil }, 1024, "=22="},
+		"nil port set resource": {func(c *ecs.ContainerInstance) { c.RemainingResources[2] = nil }, 1024, ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			reported := containerInstance("a", 1024, 1024, "80")
+			test.edit(reported)
+			client := mocks.NewECSAPI(t)
+			expectContainerInstances(client, reported)
+			state := newTestState(t, client, ecs_state.Options{})
+			if err := state.RefreshContainerInstanceState(); err != nil {
+				t.Fatal(err)
+			}
+
+			stored := ecs_state.ContainerInstance{}
+			if err := state.DB().Where("a_r_n = ?", *reported.ContainerInstanceArn).First(&stored).Error; err != nil {
+				t.Fatal(err)
+			}
+			if stored.RemainingCPU != test.cpu || stored.RemainingMemory != 1024 || stored.RemainingTCPPorts != test.remainingPorts {
+				t.Errorf("stored %d CPU, %d memory and TCP ports %q, want %d, 1024 and %q", stored.RemainingCPU, stored.RemainingMemory, stored.RemainingTCPPorts, test.cpu, test.remainingPorts)
+			}
+		})
+	}
+}
+
+func TestContainerInstanceJSONRoundTrip(t *testing.T) {
+	containerInstance := ecs_state.ContainerInstance{
+		ARN:                "arn:aws:ecs:us-east-1:123456789012:container-instance/test/a",
+		AgentConnected:     true,
+		EC2InstanceId:      "i-a",
+		RegisteredCPU:      4096,
+		RegisteredTCPPorts: "=22==2375=",
+		RegisteredUDPPorts: "=53=",
+		RemainingCPU:       1024,
+		RemainingTCPPorts:  "=22==2375==8080=",
+		RemainingUDPPorts:  "=53==5353=",
+		Status:             ecs.ContainerInstanceStatusActive,
+	}
+
+	encoded, err := json.Marshal(containerInstance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ports are rendered as numbers rather than the internal encoding
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fields["RemainingTCPPorts"], []interface{}{22.0, 2375.0, 8080.0}) || !reflect.DeepEqual(fields["RemainingUDPPorts"], []interface{}{53.0, 5353.0}) {
+		t.Errorf("encoded remaining ports as %v and %v, want lists of numbers", fields["RemainingTCPPorts"], fields["RemainingUDPPorts"])
+	}
+
+	decoded := ecs_state.ContainerInstance{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, containerInstance) {
+		t.Errorf("decoded %+v, want %+v", decoded, containerInstance)
+	}
+}
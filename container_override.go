@@ -0,0 +1,16 @@
+package ecs_state
+
+// Local representation of the resources a Task was launched with for one of its containers in place of those of its
+// TaskDefinition, and stored by gorm.  Cpu and Memory are zero where the container's own requirement was kept.
+type ContainerOverride struct {
+	ID      int    `gorm:"primary_key"`
+	TaskARN string `sql:"size:1024;index"`
+	Name    string
+	Cpu     int
+	Memory  int
+}
+
+// The table ContainerOverrides are stored in, before any Options.TablePrefix.
+func (ContainerOverride) TableName() string {
+	return "container_overrides"
+}
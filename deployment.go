@@ -0,0 +1,43 @@
+package ecs_state
+
+// Local representation of one deployment of an ECS service and stored by gorm.  Status is PRIMARY for the
+// most recent deployment and ACTIVE for earlier ones still running tasks.  CreatedAtUnix is when ECS created the
+// deployment as a Unix time, named so that gorm does not mistake it for its own CreatedAt timestamp.
+type Deployment struct {
+	ID                string `sql:"size:1024" gorm:"primary_key"`
+	ServiceARN        string `sql:"size:1024;index"`
+	Status            string
+	TaskDefinitionARN string `sql:"size:1024"`
+	DesiredCount      int
+	RunningCount      int
+	PendingCount      int
+	RolloutState      string
+	CreatedAtUnix     int
+}
+
+// The table Deployments are stored in, before any Options.TablePrefix.
+func (Deployment) TableName() string {
+	return "deployments"
+}
+
+// How far a service has rolled out its current TaskDefinition, returned by DeploymentProgress.
+type DeploymentStatus struct {
+	// The TaskDefinition the service is deploying.
+	TaskDefinitionARN string
+	// How many tasks the service wants running.
+	DesiredCount int
+	// How many of the service's tasks are RUNNING the current TaskDefinition.
+	RunningCount int
+	// How many of the service's tasks using any other TaskDefinition have not stopped yet.
+	OldTasksRemaining int
+	// Whether every desired task runs the current TaskDefinition and no old tasks remain.
+	Complete bool
+}
+
+// How many Tasks of a TaskDefinition are wanted and actually RUNNING, returned by TaskDefinitionRolloutCounts.
+type RolloutCount struct {
+	// How many Tasks have a DesiredStatus of RUNNING.
+	Desired int
+	// How many Tasks have a LastStatus of RUNNING.
+	Running int
+}
@@ -0,0 +1,83 @@
+package ecs_state
+
+// The differences between two views of a Cluster, such as those returned by FindClusterByName before and after a refresh.
+// Changed entries hold the newer copy.
+type ClusterDiff struct {
+	AddedContainerInstances   []ContainerInstance
+	RemovedContainerInstances []ContainerInstance
+	ChangedContainerInstances []ContainerInstance
+	AddedTasks                []Task
+	RemovedTasks              []Task
+	ChangedTasks              []Task
+}
+
+// Whether the diff found no differences at all.
+func (diff ClusterDiff) Empty() bool {
+	return len(diff.AddedContainerInstances) == 0 && len(diff.RemovedContainerInstances) == 0 &&
+		len(diff.ChangedContainerInstances) == 0 && len(diff.AddedTasks) == 0 &&
+		len(diff.RemovedTasks) == 0 && len(diff.ChangedTasks) == 0
+}
+
+// Compares two views of a Cluster, as loaded by FindClusterByName, returning the ContainerInstances and Tasks that
+// appeared, disappeared, or changed status or remaining resources.
+func DiffClusters(old, new Cluster) ClusterDiff {
+	diff := ClusterDiff{}
+
+	oldContainerInstances := map[string]ContainerInstance{}
+	for _, containerInstance := range old.ContainerInstances {
+		oldContainerInstances[containerInstance.ARN] = containerInstance
+	}
+	newContainerInstances := map[string]bool{}
+	for _, containerInstance := range new.ContainerInstances {
+		newContainerInstances[containerInstance.ARN] = true
+		previous, ok := oldContainerInstances[containerInstance.ARN]
+		if !ok {
+			diff.AddedContainerInstances = append(diff.AddedContainerInstances, containerInstance)
+		} else if containerInstanceChanged(previous, containerInstance) {
+			diff.ChangedContainerInstances = append(diff.ChangedContainerInstances, containerInstance)
+		}
+	}
+	for _, containerInstance := range old.ContainerInstances {
+		if !newContainerInstances[containerInstance.ARN] {
+			diff.RemovedContainerInstances = append(diff.RemovedContainerInstances, containerInstance)
+		}
+	}
+
+	oldTasks := map[string]Task{}
+	for _, task := range old.Tasks {
+		oldTasks[task.ARN] = task
+	}
+	newTasks := map[string]bool{}
+	for _, task := range new.Tasks {
+		newTasks[task.ARN] = true
+		previous, ok := oldTasks[task.ARN]
+		if !ok {
+			diff.AddedTasks = append(diff.AddedTasks, task)
+		} else if taskChanged(previous, task) {
+			diff.ChangedTasks = append(diff.ChangedTasks, task)
+		}
+	}
+	for _, task := range old.Tasks {
+		if !newTasks[task.ARN] {
+			diff.RemovedTasks = append(diff.RemovedTasks, task)
+		}
+	}
+
+	return diff
+}
+
+// Whether the status of a Task has changed between two views.
+func taskChanged(old, new Task) bool {
+	return old.LastStatus != new.LastStatus || old.DesiredStatus != new.DesiredStatus
+}
+
+// Whether the state of a ContainerInstance that matters for placement has changed between two views.
+func containerInstanceChanged(old, new ContainerInstance) bool {
+	return old.Status != new.Status ||
+		old.AgentConnected != new.AgentConnected ||
+		old.HealthStatus != new.HealthStatus ||
+		old.RemainingCPU != new.RemainingCPU ||
+		old.RemainingMemory != new.RemainingMemory ||
+		old.RemainingTCPPorts != new.RemainingTCPPorts ||
+		old.RemainingUDPPorts != new.RemainingUDPPorts
+}
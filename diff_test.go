@@ -0,0 +1,70 @@
+package ecs_state_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jhspaybar/ecs_state"
+)
+
+func TestDiffClusters(t *testing.T) {
+	old := ecs_state.Cluster{
+		ContainerInstances: []ecs_state.ContainerInstance{
+			{ARN: "unchanged", Status: "ACTIVE", RemainingCPU: 1024},
+			{ARN: "draining", Status: "ACTIVE", RemainingCPU: 1024},
+			{ARN: "busier", Status: "ACTIVE", RemainingCPU: 1024, RemainingTCPPorts: "=22="},
+			{ARN: "removed", Status: "ACTIVE"},
+		},
+		Tasks: []ecs_state.Task{
+			{ARN: "running", LastStatus: "RUNNING", DesiredStatus: "RUNNING"},
+			{ARN: "stopping", LastStatus: "RUNNING", DesiredStatus: "RUNNING"},
+			{ARN: "stopped", LastStatus: "STOPPED", DesiredStatus: "STOPPED"},
+		},
+	}
+	new := ecs_state.Cluster{
+		ContainerInstances: []ecs_state.ContainerInstance{
+			// Fields placement does not depend on are not compared
+			{ARN: "unchanged", Status: "ACTIVE", RemainingCPU: 1024, AgentVersion: "1.2.3"},
+			{ARN: "draining", Status: "DRAINING", RemainingCPU: 1024},
+			{ARN: "busier", Status: "ACTIVE", RemainingCPU: 1024, RemainingTCPPorts: "=22==8080="},
+			{ARN: "added", Status: "ACTIVE"},
+		},
+		Tasks: []ecs_state.Task{
+			{ARN: "running", LastStatus: "RUNNING", DesiredStatus: "RUNNING", StartedAt: 1},
+			{ARN: "stopping", LastStatus: "RUNNING", DesiredStatus: "STOPPED"},
+			{ARN: "started", LastStatus: "PENDING", DesiredStatus: "RUNNING"},
+		},
+	}
+
+	diff := ecs_state.DiffClusters(old, new)
+	for name, test := range map[string]struct {
+		arns []string
+		want string
+	}{
+		"added ContainerInstances":   {instanceARNs(diff.AddedContainerInstances), "added"},
+		"removed ContainerInstances": {instanceARNs(diff.RemovedContainerInstances), "removed"},
+		"changed ContainerInstances": {instanceARNs(diff.ChangedContainerInstances), "draining busier"},
+		"added Tasks":                {taskARNs(diff.AddedTasks), "started"},
+		"removed Tasks":              {taskARNs(diff.RemovedTasks), "stopped"},
+		"changed Tasks":              {taskARNs(diff.ChangedTasks), "stopping"},
+	} {
+		if strings.Join(test.arns, " ") != test.want {
+			t.Errorf("%s are %v, want %s", name, test.arns, test.want)
+		}
+	}
+	if diff.ChangedTasks[0].DesiredStatus != "STOPPED" {
+		t.Errorf("changed Task has DesiredStatus %s, want the newer STOPPED", diff.ChangedTasks[0].DesiredStatus)
+	}
+	if diff.Empty() || !ecs_state.DiffClusters(new, new).Empty() {
+		t.Error("Empty disagrees with the differences found")
+	}
+}
+
+// The ARNs of tasks, in order.
+func taskARNs(tasks []ecs_state.Task) []string {
+	arns := []string{}
+	for _, task := range tasks {
+		arns = append(arns, task.ARN)
+	}
+	return arns
+}
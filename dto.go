@@ -0,0 +1,171 @@
+package ecs_state
+
+import "strings"
+
+// Plain copies of the stored models for use as wire types, such as with gRPC or protobuf generated structs.  They
+// carry no gorm tags, ports are lists of numbers rather than the internal searchable format, and comma separated
+// columns are split into lists, so the storage schema can change without affecting them.
+
+// A Cluster free of storage details, returned by Cluster.ToDTO.
+type ClusterDTO struct {
+	ARN                               string
+	Name                              string
+	Status                            string
+	CapacityProviders                 []string
+	RunningTasksCount                 int
+	PendingTasksCount                 int
+	ActiveServicesCount               int
+	RegisteredContainerInstancesCount int
+	DefaultCapacityProviderStrategy   []CapacityProviderStrategyItemDTO
+	ContainerInstances                []ContainerInstanceDTO
+	Tasks                             []TaskDTO
+}
+
+// A CapacityProviderStrategyItem free of storage details.
+type CapacityProviderStrategyItemDTO struct {
+	CapacityProvider string
+	Weight           int
+	Base             int
+}
+
+// A ContainerInstance free of storage details, returned by ContainerInstance.ToDTO.
+type ContainerInstanceDTO struct {
+	ARN                  string
+	AgentConnected       bool
+	AgentHash            string
+	AgentVersion         string
+	AgentUpdateStatus    string
+	CapacityProviderName string
+	ClusterARN           string
+	DockerVersion        string
+	EC2InstanceId        string
+	HealthStatus         string
+	InstanceType         string
+	RegisteredCPU        int
+	RegisteredMemory     int
+	RegisteredTCPPorts   []int32
+	RegisteredUDPPorts   []int32
+	RemainingCPU         int
+	RemainingMemory      int
+	RemainingTCPPorts    []int32
+	RemainingUDPPorts    []int32
+	Status               string
+	Tasks                []TaskDTO
+}
+
+// A Task free of storage details, returned by Task.ToDTO.
+type TaskDTO struct {
+	ARN                  string
+	DesiredStatus        string
+	LastStatus           string
+	StartedBy            string
+	Group                string
+	ClusterARN           string
+	ContainerInstanceARN string
+	TaskDefinitionARN    string
+	OverrideCpu          int
+	OverrideMemory       int
+	TCPPorts             []int32
+	UDPPorts             []int32
+	Connectivity         string
+	HealthStatus         string
+	StoppedReason        string
+	StopCode             string
+	StartedAt            int
+	StoppedAt            int
+}
+
+// Converts the Cluster, including any loaded strategy, ContainerInstances, and Tasks, to a ClusterDTO.
+func (cluster Cluster) ToDTO() ClusterDTO {
+	dto := ClusterDTO{
+		ARN:                               cluster.ARN,
+		Name:                              cluster.Name,
+		Status:                            cluster.Status,
+		CapacityProviders:                 []string{},
+		RunningTasksCount:                 cluster.RunningTasksCount,
+		PendingTasksCount:                 cluster.PendingTasksCount,
+		ActiveServicesCount:               cluster.ActiveServicesCount,
+		RegisteredContainerInstancesCount: cluster.RegisteredContainerInstancesCount,
+		DefaultCapacityProviderStrategy:   []CapacityProviderStrategyItemDTO{},
+		ContainerInstances:                []ContainerInstanceDTO{},
+		Tasks:                             []TaskDTO{},
+	}
+	if len(cluster.CapacityProviders) > 0 {
+		dto.CapacityProviders = strings.Split(cluster.CapacityProviders, ",")
+	}
+	for _, item := range cluster.DefaultCapacityProviderStrategy {
+		dto.DefaultCapacityProviderStrategy = append(dto.DefaultCapacityProviderStrategy,
+			CapacityProviderStrategyItemDTO{CapacityProvider: item.CapacityProvider, Weight: item.Weight, Base: item.Base})
+	}
+	for _, containerInstance := range cluster.ContainerInstances {
+		dto.ContainerInstances = append(dto.ContainerInstances, containerInstance.ToDTO())
+	}
+	for _, task := range cluster.Tasks {
+		dto.Tasks = append(dto.Tasks, task.ToDTO())
+	}
+	return dto
+}
+
+// Converts the ContainerInstance, including any loaded Tasks, to a ContainerInstanceDTO.
+func (containerInstance ContainerInstance) ToDTO() ContainerInstanceDTO {
+	dto := ContainerInstanceDTO{
+		ARN:                  containerInstance.ARN,
+		AgentConnected:       containerInstance.AgentConnected,
+		AgentHash:            containerInstance.AgentHash,
+		AgentVersion:         containerInstance.AgentVersion,
+		AgentUpdateStatus:    containerInstance.AgentUpdateStatus,
+		CapacityProviderName: containerInstance.CapacityProviderName,
+		ClusterARN:           containerInstance.ClusterARN,
+		DockerVersion:        containerInstance.DockerVersion,
+		EC2InstanceId:        containerInstance.EC2InstanceId,
+		HealthStatus:         containerInstance.HealthStatus,
+		InstanceType:         containerInstance.InstanceType,
+		RegisteredCPU:        containerInstance.RegisteredCPU,
+		RegisteredMemory:     containerInstance.RegisteredMemory,
+		RegisteredTCPPorts:   portsDTO(containerInstance.RegisteredTCPPorts),
+		RegisteredUDPPorts:   portsDTO(containerInstance.RegisteredUDPPorts),
+		RemainingCPU:         containerInstance.RemainingCPU,
+		RemainingMemory:      containerInstance.RemainingMemory,
+		RemainingTCPPorts:    portsDTO(containerInstance.RemainingTCPPorts),
+		RemainingUDPPorts:    portsDTO(containerInstance.RemainingUDPPorts),
+		Status:               containerInstance.Status,
+		Tasks:                []TaskDTO{},
+	}
+	for _, task := range containerInstance.Tasks {
+		dto.Tasks = append(dto.Tasks, task.ToDTO())
+	}
+	return dto
+}
+
+// Converts the Task to a TaskDTO.
+func (task Task) ToDTO() TaskDTO {
+	return TaskDTO{
+		ARN:                  task.ARN,
+		DesiredStatus:        task.DesiredStatus,
+		LastStatus:           task.LastStatus,
+		StartedBy:            task.StartedBy,
+		Group:                task.Group,
+		ClusterARN:           task.ClusterARN,
+		ContainerInstanceARN: task.ContainerInstanceARN,
+		TaskDefinitionARN:    task.TaskDefinitionARN,
+		OverrideCpu:          task.OverrideCpu,
+		OverrideMemory:       task.OverrideMemory,
+		TCPPorts:             portsDTO(task.TCPPorts),
+		UDPPorts:             portsDTO(task.UDPPorts),
+		Connectivity:         task.Connectivity,
+		HealthStatus:         task.HealthStatus,
+		StoppedReason:        task.StoppedReason,
+		StopCode:             task.StopCode,
+		StartedAt:            task.StartedAt,
+		StoppedAt:            task.StoppedAt,
+	}
+}
+
+// Parses ports in the internal searchable format into the int32 port numbers used by the DTOs.
+func portsDTO(encoded string) []int32 {
+	ports := []int32{}
+	for _, port := range ParsePorts(encoded) {
+		ports = append(ports, int32(port))
+	}
+	return ports
+}
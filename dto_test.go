@@ -0,0 +1,68 @@
+package ecs_state_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jhspaybar/ecs_state"
+)
+
+func TestClusterToDTO(t *testing.T) {
+	task := ecs_state.Task{
+		ARN:                  "task",
+		LastStatus:           "RUNNING",
+		ContainerInstanceARN: "instance",
+		TCPPorts:             "=8080=",
+		UDPPorts:             "=53==5353=",
+		StartedAt:            1700000000,
+	}
+	cluster := ecs_state.Cluster{
+		ARN:                             "cluster",
+		Name:                            "test",
+		CapacityProviders:               "spot,on-demand",
+		DefaultCapacityProviderStrategy: []ecs_state.CapacityProviderStrategyItem{{CapacityProvider: "spot", Weight: 2, Base: 1}},
+		ContainerInstances: []ecs_state.ContainerInstance{{
+			ARN:                "instance",
+			RegisteredTCPPorts: "=22=",
+			RemainingTCPPorts:  "=22==8080=",
+			RemainingCPU:       1024,
+			Tasks:              []ecs_state.Task{task},
+		}},
+		Tasks: []ecs_state.Task{task},
+	}
+
+	taskDTO := ecs_state.TaskDTO{
+		ARN:                  "task",
+		LastStatus:           "RUNNING",
+		ContainerInstanceARN: "instance",
+		TCPPorts:             []int32{8080},
+		UDPPorts:             []int32{53, 5353},
+		StartedAt:            1700000000,
+	}
+	want := ecs_state.ClusterDTO{
+		ARN:                             "cluster",
+		Name:                            "test",
+		CapacityProviders:               []string{"spot", "on-demand"},
+		DefaultCapacityProviderStrategy: []ecs_state.CapacityProviderStrategyItemDTO{{CapacityProvider: "spot", Weight: 2, Base: 1}},
+		ContainerInstances: []ecs_state.ContainerInstanceDTO{{
+			ARN:                "instance",
+			RegisteredTCPPorts: []int32{22},
+			RegisteredUDPPorts: []int32{},
+			RemainingTCPPorts:  []int32{22, 8080},
+			RemainingUDPPorts:  []int32{},
+			RemainingCPU:       1024,
+			Tasks:              []ecs_state.TaskDTO{taskDTO},
+		}},
+		Tasks: []ecs_state.TaskDTO{taskDTO},
+	}
+	if dto := cluster.ToDTO(); !reflect.DeepEqual(dto, want) {
+		t.Errorf("converted to %+v, want %+v", dto, want)
+	}
+
+	// Empty lists rather than nil, so they encode as [] rather than null
+	empty := ecs_state.Cluster{ContainerInstances: []ecs_state.ContainerInstance{{}}}.ToDTO()
+	if empty.CapacityProviders == nil || empty.DefaultCapacityProviderStrategy == nil || empty.Tasks == nil ||
+		empty.ContainerInstances[0].Tasks == nil || empty.ContainerInstances[0].RemainingTCPPorts == nil {
+		t.Errorf("converted an empty Cluster to %+v, want empty lists", empty)
+	}
+}
@@ -0,0 +1,21 @@
+package ecs_state
+
+import "github.com/aws/aws-sdk-go/service/ecs"
+
+//go:generate mockery --name ECSAPI --output mocks --outpkg mocks
+
+// The subset of the ECS API used by ecs_state.  It is satisfied by *ecs.ECS as well as ecsiface.ECSAPI from the
+// AWS SDK, so a generated mock of either can be provided to Initialize to test refresh behavior without AWS access.
+type ECSAPI interface {
+	DescribeClusters(*ecs.DescribeClustersInput) (*ecs.DescribeClustersOutput, error)
+	ListContainerInstancesPages(*ecs.ListContainerInstancesInput, func(*ecs.ListContainerInstancesOutput, bool) bool) error
+	DescribeContainerInstances(*ecs.DescribeContainerInstancesInput) (*ecs.DescribeContainerInstancesOutput, error)
+	ListTasksPages(*ecs.ListTasksInput, func(*ecs.ListTasksOutput, bool) bool) error
+	DescribeTasks(*ecs.DescribeTasksInput) (*ecs.DescribeTasksOutput, error)
+	DescribeTaskDefinition(*ecs.DescribeTaskDefinitionInput) (*ecs.DescribeTaskDefinitionOutput, error)
+	ListServicesPages(*ecs.ListServicesInput, func(*ecs.ListServicesOutput, bool) bool) error
+	DescribeServices(*ecs.DescribeServicesInput) (*ecs.DescribeServicesOutput, error)
+}
+
+// Ensure the AWS SDK client can always be provided directly.
+var _ ECSAPI = (*ecs.ECS)(nil)
@@ -7,10 +7,12 @@ package ecs_state
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -24,14 +26,32 @@ import (
 type State struct {
 	clusterName string
 	db          gorm.DB
-	ecs_client  *ecs.ECS
+	ecs_client  ECSAPI
 	log         Logger
+	options     Options
+
+	readOnlyLock sync.Mutex
+	readOnlyDB   *gorm.DB
+
+	refreshLock   sync.Mutex
+	lastRefresh   map[string]time.Time
+	refreshFlight flightGroup
+
+	clusterCheck sync.Once
+
+	reservationLock   sync.Mutex
+	reservations      map[ReservationID]Reservation
+	lastReservationID ReservationID
 }
 
-// Create a new State object.  The clusterName is the cluster to track, ecs_client should be provided by the caller
-// with proper credentials preferably scoped to read only access to ECS APIs, and the logger can use ecs_state.DefaultLogger
-// for output on stdout, or the user can provide a custom logger instead.
-func Initialize(clusterName string, ecs_client *ecs.ECS, logger Logger) *State {
+// Create a new State object.  The clusterName is the cluster to track, by name or full ARN, ecs_client is any ECSAPI
+// such as *ecs.ECS with read only credentials, and the logger can use ecs_state.DefaultLogger for output on stdout.
+func Initialize(clusterName string, ecs_client ECSAPI, logger Logger) *State {
+	return InitializeWithOptions(clusterName, ecs_client, logger, Options{})
+}
+
+// Create a new State object as with Initialize, additionally tuning its behavior with the provided Options.
+func InitializeWithOptions(clusterName string, ecs_client ECSAPI, logger Logger, options Options) *State {
 	logger.Info("Intializing ecs_state for cluster ", clusterName)
 
 	db, err := gorm.Open("sqlite3", ":memory:")
@@ -40,11 +60,23 @@ func Initialize(clusterName string, ecs_client *ecs.ECS, logger Logger) *State {
 		os.Exit(1)
 	}
 
+	// Each connection to ":memory:" opens a database of its own, so every query, ReadOnlyDB's included, waits its turn
+	// for the single connection holding local state.  Queries therefore never see a refresh transaction half applied.
+	db.DB().SetMaxOpenConns(1)
 	db.SetLogger(logger)
-	db.AutoMigrate(&Cluster{}, &ContainerInstance{}, &Task{}, &TaskDefinition{})
-	db.Model(&ContainerInstance{}).AddIndex("idx_remaining_cpu_memory_tcp_udp", "remaining_cpu", "remaining_memory", "remaining_tcp_ports", "remaining_udp_ports")
+	db = *prefixTables(&db, options.TablePrefix)
+	for _, model := range []tabler{&Cluster{}, &CapacityProviderStrategyItem{}, &ContainerInstance{}, &Attribute{}, &Task{}, &TaskDefinition{}, &ContainerDefinition{}, &Service{}, &Deployment{}, &InstancePort{}, &Tag{}, &TaskDefinitionAlias{}, &TaskHistory{}, &TaskSet{}, &ContainerOverride{}} {
+		db.Table(options.TablePrefix + model.TableName()).AutoMigrate(model)
+	}
+	instances, tasks := options.TablePrefix+ContainerInstance{}.TableName(), options.TablePrefix+Task{}.TableName()
+	db.Table(instances).AddIndex(options.TablePrefix+"idx_remaining_cpu_memory_tcp_udp", "remaining_cpu", "remaining_memory", "remaining_tcp_ports", "remaining_udp_ports")
+	db.Table(tasks).AddIndex(options.TablePrefix+"idx_tasks_cluster_status_definition", "cluster_a_r_n", "last_status", "task_definition_a_r_n")
+	db.Table(tasks).AddIndex(options.TablePrefix+"idx_tasks_definition_status", "task_definition_a_r_n", "last_status")
+	if options.AfterMigrate != nil {
+		options.AfterMigrate(&db)
+	}
 
-	return &State{clusterName: clusterName, db: db, ecs_client: ecs_client, log: logger}
+	return &State{clusterName: clusterName, db: db, ecs_client: ecs_client, log: logger, options: options}
 }
 
 // Provides direct access to the database through gorm to allow more advanced queries against state.
@@ -52,8 +84,39 @@ func (state *State) DB() *gorm.DB {
 	return &state.db
 }
 
-// Will parse and log any AWS errors received while contacting ECS.
-func (state *State) handleAwsError(err error) {
+// Provides gorm access to the database that only runs single SELECT statements, returning ErrReadOnlyDatabase for
+// anything else.  Queries wait for any refresh in progress, so never see it partly applied.
+func (state *State) ReadOnlyDB() (*gorm.DB, error) {
+	state.readOnlyLock.Lock()
+	defer state.readOnlyLock.Unlock()
+	if state.readOnlyDB != nil {
+		return state.readOnlyDB, nil
+	}
+
+	db, err := gorm.Open("sqlite3", readOnlyDB{state.db.DB()})
+	if err != nil {
+		return nil, err
+	}
+	db.SetLogger(state.log)
+	db = *prefixTables(&db, state.options.TablePrefix)
+
+	state.readOnlyDB = &db
+	return state.readOnlyDB, nil
+}
+
+// Closes the database, along with any handle returned by ReadOnlyDB, releasing the memory held by the local
+// state.  The State is unusable after Close, and queries against it return errors.
+func (state *State) Close() error {
+	state.log.Info("entering Close()")
+	state.readOnlyLock.Lock()
+	defer state.readOnlyLock.Unlock()
+	// The read-only database shares the connection closed below
+	state.readOnlyDB = nil
+	return state.db.Close()
+}
+
+// Will parse and log any AWS errors received while contacting ECS, returning them wrapped in an ECSError.
+func (state *State) handleAwsError(err error) error {
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok {
 			// Generic AWS error with Code, Message, and original error (if any)
@@ -67,7 +130,9 @@ func (state *State) handleAwsError(err error) {
 			// error which satisfies the awserr.Error interface.
 			state.log.Error(err.Error())
 		}
+		return &ECSError{Err: err}
 	}
+	return nil
 }
 
 // Many ECS Apis return a generic Failure object, this methods parses and logs generic Failures.
@@ -75,318 +140,2256 @@ func (state *State) handleFailures(failures []*ecs.Failure) {
 	if len(failures) != 0 {
 		state.log.Warn("Encountered", len(failures), "failures when contacting ECS")
 		for _, failure := range failures {
-			state.log.Warn("Failure ARN:", *failure.Arn, ", Reason:", *failure.Reason)
+			state.log.Warn("Failure ARN:", aws.StringValue(failure.Arn), ", Reason:", aws.StringValue(failure.Reason))
 		}
 	}
 }
 
-// Performs ECS DescribeCluster call on the clusterName provided at Initialization time and updates the local copy of state.
-func (state *State) RefreshClusterState() {
+// Performs ECS DescribeCluster call on the cluster name or ARN provided at Initialization time and updates the local
+// copy of state.  Returns ErrClusterNotFound if ECS does not know the cluster, usually due to the wrong region or account.
+func (state *State) RefreshClusterState() error {
 	state.log.Info("entering RefreshClusterState()")
+	return state.refreshFlight.do(RefreshKindCluster, state.refreshCluster)
+}
+
+// Refreshes the cluster on behalf of RefreshClusterState.
+func (state *State) refreshCluster() (err error) {
+	start := state.now()
+	count := 0
+	defer func() { state.finishRefresh(RefreshKindCluster, start, count, err) }()
+
 	params := &ecs.DescribeClustersInput{
 		Clusters: []*string{
 			aws.String(state.clusterName),
 		},
+		Include: []*string{
+			aws.String(ecs.ClusterFieldStatistics),
+		},
 	}
 	resp, err := state.ecs_client.DescribeClusters(params)
 	if err != nil {
-		state.handleAwsError(err)
-		return
+		return state.handleAwsError(err)
 	}
 
 	state.handleFailures(resp.Failures)
+	state.clusterCheck.Do(func() { state.checkClusterFound(resp.Clusters) })
+	if len(resp.Clusters) == 0 {
+		return ErrClusterNotFound
+	}
+
+	_, err = state.applyRefresh(func(tx *gorm.DB) ([]StateChange, error) {
+		for _, cluster := range resp.Clusters {
+			if cluster == nil || cluster.ClusterArn == nil {
+				state.log.Warn("Skipping cluster without an ARN:", cluster)
+				continue
+			}
+			count++
+			clusterModel := Cluster{}
+			assignment := state.clusterAssignment(cluster)
+			strategy := assignment.DefaultCapacityProviderStrategy
+			assignment.DefaultCapacityProviderStrategy = nil
+			err := tx.Where("a_r_n = ?", *cluster.ClusterArn).Attrs(Cluster{ARN: *cluster.ClusterArn}).Assign(assignment).FirstOrCreate(&clusterModel).Error
+			if err != nil {
+				return nil, err
+			}
+			// Written as columns directly since a struct Assign() skips empty and zero values.
+			err = tx.Model(&clusterModel).UpdateColumns(map[string]interface{}{
+				"capacity_providers":                   assignment.CapacityProviders,
+				"running_tasks_count":                  assignment.RunningTasksCount,
+				"pending_tasks_count":                  assignment.PendingTasksCount,
+				"active_services_count":                assignment.ActiveServicesCount,
+				"registered_container_instances_count": assignment.RegisteredContainerInstancesCount,
+			}).Error
+			if err != nil {
+				return nil, err
+			}
+
+			// Strategy items have no identity of their own within ECS, so they are replaced wholesale.
+			if err := tx.Where("cluster_a_r_n = ?", clusterModel.ARN).Delete(CapacityProviderStrategyItem{}).Error; err != nil {
+				return nil, err
+			}
+			for _, item := range strategy {
+				if err := tx.Create(&item).Error; err != nil {
+					return nil, err
+				}
+			}
+			state.log.Debug(fmt.Sprintf("Refreshed cluster: %+v", cluster))
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// Explains the result of the first cluster refresh, since an ECS client configured for the wrong region or account
+// otherwise only shows up as empty local state.
+func (state *State) checkClusterFound(clusters []*ecs.Cluster) {
+	if len(clusters) == 0 {
+		state.log.Warn("ECS returned no cluster named", state.clusterName+".", "If it exists, check that the ECS client is configured for its region and with credentials for its account.")
+		return
+	}
+	for _, cluster := range clusters {
+		if cluster != nil && aws.Int64Value(cluster.RegisteredContainerInstancesCount) == 0 {
+			state.log.Info("Cluster", state.clusterName, "was found but has no registered ContainerInstances")
+		}
+	}
+}
+
+// Creates a Cluster model to be used in a gorm Assign() call
+func (state *State) clusterAssignment(cluster *ecs.Cluster) Cluster {
+	assignment := Cluster{Name: aws.StringValue(cluster.ClusterName), Status: aws.StringValue(cluster.Status)}
+	capacityProviders := []string{}
+	for _, capacityProvider := range cluster.CapacityProviders {
+		if capacityProvider != nil {
+			capacityProviders = append(capacityProviders, *capacityProvider)
+		}
+	}
+	assignment.CapacityProviders = strings.Join(capacityProviders, ",")
+
+	if cluster.RunningTasksCount != nil {
+		assignment.RunningTasksCount = int(*cluster.RunningTasksCount)
+	}
+	if cluster.PendingTasksCount != nil {
+		assignment.PendingTasksCount = int(*cluster.PendingTasksCount)
+	}
+	if cluster.ActiveServicesCount != nil {
+		assignment.ActiveServicesCount = int(*cluster.ActiveServicesCount)
+	}
+	if cluster.RegisteredContainerInstancesCount != nil {
+		assignment.RegisteredContainerInstancesCount = int(*cluster.RegisteredContainerInstancesCount)
+	}
 
-	for _, cluster := range resp.Clusters {
-		clusterModel := Cluster{}
-		state.db.Where(Cluster{ARN: *cluster.ClusterArn}).Assign(Cluster{Name: *cluster.ClusterName, Status: *cluster.Status}).FirstOrCreate(&clusterModel)
-		state.log.Debug(fmt.Sprintf("Refreshed cluster: %+v", cluster))
+	for _, item := range cluster.DefaultCapacityProviderStrategy {
+		if item == nil || item.CapacityProvider == nil {
+			continue
+		}
+		strategyItem := CapacityProviderStrategyItem{ClusterARN: aws.StringValue(cluster.ClusterArn), CapacityProvider: *item.CapacityProvider}
+		if item.Weight != nil {
+			strategyItem.Weight = int(*item.Weight)
+		}
+		if item.Base != nil {
+			strategyItem.Base = int(*item.Base)
+		}
+		assignment.DefaultCapacityProviderStrategy = append(assignment.DefaultCapacityProviderStrategy, strategyItem)
 	}
+	return assignment
 }
 
 // Lists and Describes ContainerInstances in the ECS API and stores them in a more queryable form locally.
-// Any ContainerInstances no longer returned by ECS, for example if they have been deregistered, will be
-// removed from the local view of state as well.
-func (state *State) RefreshContainerInstanceState() {
-	state.log.Info("entering RefreshContainerInstanceState()")
+// Any ContainerInstances no longer returned by ECS will be removed once the StaleRecordTTL has passed, and if any
+// page fails to describe the error is returned with local state unchanged.
+func (state *State) RefreshContainerInstanceState() error {
+	return state.RefreshContainerInstanceStateWithContext(context.Background(), nil)
+}
+
+// Refreshes ContainerInstances as RefreshContainerInstanceState does, stopping once ctx is done and sending the running
+// total to progress, if not nil, after each page.  Concurrent calls share the refresh of the first.
+func (state *State) RefreshContainerInstanceStateWithContext(ctx context.Context, progress chan<- RefreshProgress) error {
+	state.log.Info("entering RefreshContainerInstanceStateWithContext()")
+	if progress != nil {
+		defer close(progress)
+	}
+	return state.refreshFlight.do(RefreshKindContainerInstances, func() error {
+		return state.refreshContainerInstances(ctx, progress)
+	})
+}
+
+// Refreshes ContainerInstances on behalf of RefreshContainerInstanceStateWithContext.
+func (state *State) refreshContainerInstances(ctx context.Context, progress chan<- RefreshProgress) (err error) {
+	start := state.now()
+	count := 0
+	defer func() { state.finishRefresh(RefreshKindContainerInstances, start, count, err) }()
+
 	params := &ecs.ListContainerInstancesInput{
 		Cluster: aws.String(state.clusterName),
 	}
 
-	cluster := state.FindClusterByName(state.clusterName)
-	refreshTime := int(time.Now().Unix())
-	err := state.ecs_client.ListContainerInstancesPages(params, func(page *ecs.ListContainerInstancesOutput, lastPage bool) bool {
-		params := &ecs.DescribeContainerInstancesInput{
-			ContainerInstances: page.ContainerInstanceArns,
-			Cluster:            aws.String(state.clusterName),
+	cluster, err := state.FindClusterByNameE(state.clusterName)
+	if err == ErrClusterNotFound {
+		state.log.Warn("Cluster", state.clusterName, "not found locally, refreshing it before its ContainerInstances")
+		if err := state.RefreshClusterState(); err == nil {
+			cluster, _ = state.FindClusterByNameE(state.clusterName)
+		}
+	}
+	described := []*ecs.ContainerInstance{}
+	var describeErr error
+	err = state.ecs_client.ListContainerInstancesPages(params, func(page *ecs.ListContainerInstancesOutput, lastPage bool) bool {
+		if ctx.Err() != nil {
+			return false
 		}
-		resp, err := state.ecs_client.DescribeContainerInstances(params)
+		resp, err := state.ecs_client.DescribeContainerInstances(state.describeContainerInstancesInput(page.ContainerInstanceArns))
 		if err != nil {
-			state.handleAwsError(err)
-			return !lastPage
+			describeErr = state.handleAwsError(err)
+			return false
 		}
 
 		state.handleFailures(resp.Failures)
+		described = append(described, resp.ContainerInstances...)
+		state.reportProgress(ctx, progress, RefreshKindContainerInstances, len(described))
+		return !lastPage
+	})
+	if err != nil {
+		return state.handleAwsError(err)
+	}
+	if describeErr != nil {
+		return describeErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
-		for _, containerInstance := range resp.ContainerInstances {
+	refreshTime := int(state.now().Unix())
+	refreshedARNs := map[string]bool{}
+	// Reservations wait until the refresh is applied and the ones it supersedes are cleared, so that none is made
+	// against the old remaining resources and then forgotten, or released onto the new ones.
+	state.reservationLock.Lock()
+	changes, err := state.applyRefresh(func(tx *gorm.DB) ([]StateChange, error) {
+		changes := []StateChange{}
+		for _, containerInstance := range described {
+			if containerInstance.ContainerInstanceArn == nil {
+				state.log.Warn("Skipping ContainerInstance without an ARN:", containerInstance)
+				continue
+			}
+			count++
 			containerInstanceModel := ContainerInstance{}
 			finder := ContainerInstance{
 				ARN: *containerInstance.ContainerInstanceArn,
 			}
 			assignment := state.containerInstanceAssignment(cluster, containerInstance)
 			assignment.RefreshTime = refreshTime
-			state.db.Where(finder).Assign(assignment).FirstOrCreate(&containerInstanceModel)
+			if err := state.resolveMissingResources(tx, &assignment, finder.ARN); err != nil {
+				return nil, err
+			}
+			if err := state.countFreeDynamicPorts(tx, &assignment, finder.ARN); err != nil {
+				return nil, err
+			}
+			if state.trackingChanges() {
+				existing := ContainerInstance{}
+				query := tx.Where("a_r_n = ?", finder.ARN).First(&existing)
+				if query.RecordNotFound() {
+					changes = append(changes, StateChange{Kind: RefreshKindContainerInstances, Action: ChangeInsert, ARN: finder.ARN})
+				} else if query.Error != nil {
+					return nil, query.Error
+				} else if containerInstanceChanged(existing, assignment) {
+					changes = append(changes, StateChange{Kind: RefreshKindContainerInstances, Action: ChangeUpdate, ARN: finder.ARN})
+				}
+			}
+			if err := tx.Where("a_r_n = ?", finder.ARN).Attrs(finder).Assign(assignment).FirstOrCreate(&containerInstanceModel).Error; err != nil {
+				return nil, err
+			}
+			// Written as columns directly since a struct Assign() skips an instance with nothing remaining, or whose
+			// health is no longer reported
+			err := tx.Model(&containerInstanceModel).UpdateColumns(map[string]interface{}{
+				"remaining_cpu":          assignment.RemainingCPU,
+				"remaining_memory":       assignment.RemainingMemory,
+				"free_dynamic_tcp_ports": assignment.FreeDynamicTCPPorts,
+				"free_dynamic_udp_ports": assignment.FreeDynamicUDPPorts,
+				"health_status":          assignment.HealthStatus,
+			}).Error
+			if err != nil {
+				return nil, err
+			}
+			if err := state.storeAttributes(tx, finder.ARN, containerInstance.Attributes); err != nil {
+				return nil, err
+			}
+			// The assignment leaves the ARN to the finder
+			assignment.ARN = finder.ARN
+			if err := state.storeInstancePorts(tx, assignment); err != nil {
+				return nil, err
+			}
+			if err := state.storeTags(tx, finder.ARN, containerInstance.Tags); err != nil {
+				return nil, err
+			}
+			if err := state.trackAgentConnection(tx, containerInstanceModel, assignment.AgentConnected, refreshTime); err != nil {
+				return nil, err
+			}
+			refreshedARNs[finder.ARN] = true
 			state.log.Debug(fmt.Sprintf("Refreshed ContainerInstance: %+v", containerInstance))
 		}
 
-		return !lastPage
+		oldContainerInstances := []ContainerInstance{}
+		if err := tx.Where("refresh_time < ?", state.staleCutoff(refreshTime)).Find(&oldContainerInstances).Error; err != nil {
+			return nil, err
+		}
+		state.log.Debug(fmt.Sprintf("Found %d old Container Instances", len(oldContainerInstances)))
+		for _, oldContainerInstance := range oldContainerInstances {
+			if err := tx.Where("container_instance_a_r_n = ?", oldContainerInstance.ARN).Delete(Attribute{}).Error; err != nil {
+				return nil, err
+			}
+			if err := tx.Where("container_instance_a_r_n = ?", oldContainerInstance.ARN).Delete(InstancePort{}).Error; err != nil {
+				return nil, err
+			}
+			if err := tx.Where("resource_a_r_n = ?", oldContainerInstance.ARN).Delete(Tag{}).Error; err != nil {
+				return nil, err
+			}
+			if err := tx.Delete(&oldContainerInstance).Error; err != nil {
+				return nil, err
+			}
+			changes = append(changes, StateChange{Kind: RefreshKindContainerInstances, Action: ChangeDelete, ARN: oldContainerInstance.ARN})
+		}
+		return changes, nil
 	})
-
+	// Remaining resources now reflect ECS, so any local reservations on these instances have been superseded
+	if err == nil && !state.options.DryRun {
+		state.clearReservations(refreshedARNs)
+	}
+	state.reservationLock.Unlock()
 	if err != nil {
-		state.handleAwsError(err)
-		return
+		return err
 	}
+	state.reportChanges(changes)
+	return nil
+}
 
-	oldContainerInstances := []ContainerInstance{}
-	state.DB().Where("refresh_time < ?", refreshTime).Find(&oldContainerInstances)
-	state.log.Debug(fmt.Sprintf("Found %d old Container Instances", len(oldContainerInstances)))
-	for _, oldContainerInstance := range oldContainerInstances {
-		state.DB().Delete(&oldContainerInstance)
+// Returns the ContainerInstances in local state which ECS no longer lists, and which the next refresh will therefore
+// remove.  Only ListContainerInstances is called, and local state is left untouched.
+func (state *State) FindContainerInstancesMissingFromECS() ([]ContainerInstance, error) {
+	state.log.Info("entering FindContainerInstancesMissingFromECS()")
+	missing := []ContainerInstance{}
+	params := &ecs.ListContainerInstancesInput{
+		Cluster: aws.String(state.clusterName),
+	}
+	listed := map[string]bool{}
+	err := state.ecs_client.ListContainerInstancesPages(params, func(page *ecs.ListContainerInstancesOutput, lastPage bool) bool {
+		for _, arn := range page.ContainerInstanceArns {
+			listed[aws.StringValue(arn)] = true
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return missing, state.handleAwsError(err)
 	}
 
+	containerInstances := []ContainerInstance{}
+	if err := state.DB().Find(&containerInstances).Error; err != nil {
+		return missing, err
+	}
+	for _, containerInstance := range containerInstances {
+		if !listed[containerInstance.ARN] {
+			missing = append(missing, containerInstance)
+		}
+	}
+	state.log.Debug(fmt.Sprintf("Found %d ContainerInstances missing from ECS", len(missing)))
+	return missing, nil
 }
 
 // Lists and Describes Tasks in the ECS API and stores them in a more queryable form locally.
-// Any Tasks no longer returned by ECS, for example if they have been stopped, will be
-// removed from the local view of state as well.
-func (state *State) RefreshTaskState() {
-	params := &ecs.ListTasksInput{
-		Cluster: aws.String(state.clusterName),
+// Any Tasks no longer returned by ECS will be removed once the StaleRecordTTL has passed, and if any
+// page fails to describe the error is returned with local state unchanged.
+func (state *State) RefreshTaskState() error {
+	return state.RefreshTaskStateWithStatus("")
+}
+
+// Refreshes only the Tasks with the given desired status, or every Task if it is empty, as RefreshTaskState does.
+// Only local Tasks with the same desired status are considered for removal.
+func (state *State) RefreshTaskStateWithStatus(desiredStatus string) error {
+	state.log.Info("entering RefreshTaskStateWithStatus()", desiredStatus)
+	return state.sharedTaskRefresh(context.Background(), desiredStatus, nil)
+}
+
+// Refreshes Tasks as RefreshTaskState does, stopping once ctx is done and sending the running total to progress, if
+// not nil, after each page.  Concurrent calls share the refresh of the first.
+func (state *State) RefreshTaskStateWithContext(ctx context.Context, progress chan<- RefreshProgress) error {
+	state.log.Info("entering RefreshTaskStateWithContext()")
+	if progress != nil {
+		defer close(progress)
 	}
+	return state.sharedTaskRefresh(ctx, "", progress)
+}
+
+// Refreshes Tasks with the given desired status, joining any refresh of the same desired status already in progress.
+func (state *State) sharedTaskRefresh(ctx context.Context, desiredStatus string, progress chan<- RefreshProgress) error {
+	return state.refreshFlight.do(RefreshKindTasks+":"+desiredStatus, func() error {
+		return state.refreshTasks(ctx, desiredStatus, progress)
+	})
+}
 
-	refreshTime := int(time.Now().Unix())
-	err := state.ecs_client.ListTasksPages(params, func(page *ecs.ListTasksOutput, lastPage bool) bool {
-		params := &ecs.DescribeTasksInput{
-			Tasks:   page.TaskArns,
-			Cluster: aws.String(state.clusterName),
+// Refreshes the Tasks with the given desired status, or every Task if it is empty, on behalf of the public refresh methods.
+func (state *State) refreshTasks(ctx context.Context, desiredStatus string, progress chan<- RefreshProgress) (err error) {
+	start := state.now()
+	count := 0
+	defer func() {
+		// A filtered refresh leaves the other Tasks as they were, so it does not count as a refresh of them all
+		if len(desiredStatus) > 0 {
+			state.observeRefresh(RefreshKindTasks, start, count, err)
+			return
 		}
-		resp, err := state.ecs_client.DescribeTasks(params)
-		if err != nil {
-			state.handleAwsError(err)
-			return !lastPage
+		state.finishRefresh(RefreshKindTasks, start, count, err)
+	}()
+
+	// ECS only lists RUNNING Tasks unless asked otherwise, so stopped Tasks are listed as well when they are to be
+	// retained, or they would be swept before their StoppedReason was ever seen.
+	listParams := []*ecs.ListTasksInput{{
+		Cluster: aws.String(state.clusterName),
+	}}
+	if len(desiredStatus) > 0 {
+		listParams[0].DesiredStatus = aws.String(desiredStatus)
+	} else if state.options.StoppedTaskRetention > 0 {
+		listParams = append(listParams, &ecs.ListTasksInput{
+			Cluster:       aws.String(state.clusterName),
+			DesiredStatus: aws.String(ecs.DesiredStatusStopped),
+		})
+	}
+
+	described := []*ecs.Task{}
+	var describeErr error
+	for _, params := range listParams {
+		if err != nil || describeErr != nil || ctx.Err() != nil {
+			break
 		}
+		err = state.ecs_client.ListTasksPages(params, func(page *ecs.ListTasksOutput, lastPage bool) bool {
+			if ctx.Err() != nil {
+				return false
+			}
+			params := &ecs.DescribeTasksInput{
+				Tasks:   page.TaskArns,
+				Cluster: aws.String(state.clusterName),
+			}
+			if state.options.IncludeTags {
+				params.Include = []*string{aws.String(ecs.TaskFieldTags)}
+			}
+			resp, err := state.ecs_client.DescribeTasks(params)
+			if err != nil {
+				describeErr = state.handleAwsError(err)
+				return false
+			}
 
-		state.handleFailures(resp.Failures)
+			state.handleFailures(resp.Failures)
+			described = append(described, resp.Tasks...)
+			state.reportProgress(ctx, progress, RefreshKindTasks, len(described))
+			return !lastPage
+		})
+	}
+	if err != nil {
+		return state.handleAwsError(err)
+	}
+	if describeErr != nil {
+		return describeErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
-		for _, task := range resp.Tasks {
+	refreshTime := int(state.now().Unix())
+	// Reservations wait for the free dynamic ports to be recounted, as outstanding ones are deducted again.
+	state.reservationLock.Lock()
+	changes, err := state.applyRefresh(func(tx *gorm.DB) ([]StateChange, error) {
+		changes := []StateChange{}
+		for _, task := range described {
+			if task.TaskArn == nil {
+				state.log.Warn("Skipping Task without an ARN:", task)
+				continue
+			}
+			count++
 			taskModel := Task{}
 			finder := Task{
 				ARN: *task.TaskArn,
 			}
 			assignment := state.taskAssignment(task)
 			assignment.RefreshTime = refreshTime
-			state.DB().Where(finder).Assign(assignment).FirstOrCreate(&taskModel)
+			if state.trackingChanges() {
+				existing := Task{}
+				query := tx.Where("a_r_n = ?", finder.ARN).First(&existing)
+				if query.RecordNotFound() {
+					changes = append(changes, StateChange{Kind: RefreshKindTasks, Action: ChangeInsert, ARN: finder.ARN})
+				} else if query.Error != nil {
+					return nil, query.Error
+				} else if taskChanged(existing, assignment) {
+					changes = append(changes, StateChange{Kind: RefreshKindTasks, Action: ChangeUpdate, ARN: finder.ARN})
+				}
+			}
+			containerOverrides := assignment.ContainerOverrides
+			assignment.ContainerOverrides = nil
+			if err := tx.Where("a_r_n = ?", finder.ARN).Attrs(finder).Assign(assignment).FirstOrCreate(&taskModel).Error; err != nil {
+				return nil, err
+			}
+			if err := state.storeContainerOverrides(tx, finder.ARN, containerOverrides); err != nil {
+				return nil, err
+			}
+			if err := state.storeTags(tx, finder.ARN, task.Tags); err != nil {
+				return nil, err
+			}
 			state.log.Debug(fmt.Sprintf("Refreshed Task: %+v", task))
 		}
 
-		return !lastPage
+		oldTasks := []Task{}
+		staleTasks := tx.Where("refresh_time < ?", state.staleCutoff(refreshTime))
+		if len(desiredStatus) > 0 {
+			staleTasks = staleTasks.Where("desired_status = ?", desiredStatus)
+		}
+		if state.options.StoppedTaskRetention > 0 {
+			retainedSince := int(state.now().Add(-state.options.StoppedTaskRetention).Unix())
+			staleTasks = staleTasks.Where("NOT (last_status = ? AND stopped_at >= ?)", ecs.DesiredStatusStopped, retainedSince)
+		}
+		if err := staleTasks.Find(&oldTasks).Error; err != nil {
+			return nil, err
+		}
+		state.log.Debug(fmt.Sprintf("Found %d old Tasks", len(oldTasks)))
+		for _, oldTask := range oldTasks {
+			if err := state.recordTaskHistory(tx, oldTask, refreshTime); err != nil {
+				return nil, err
+			}
+			if err := tx.Where("resource_a_r_n = ?", oldTask.ARN).Delete(Tag{}).Error; err != nil {
+				return nil, err
+			}
+			if err := tx.Where("task_a_r_n = ?", oldTask.ARN).Delete(ContainerOverride{}).Error; err != nil {
+				return nil, err
+			}
+			if err := tx.Delete(&oldTask).Error; err != nil {
+				return nil, err
+			}
+			changes = append(changes, StateChange{Kind: RefreshKindTasks, Action: ChangeDelete, ARN: oldTask.ARN})
+		}
+		if err := state.trimTaskHistory(tx); err != nil {
+			return nil, err
+		}
+		// The ports bound by Tasks have changed, and ECS does not report those it chose dynamically
+		return changes, state.recountFreeDynamicPorts(tx)
 	})
-
+	state.reservationLock.Unlock()
 	if err != nil {
-		state.handleAwsError(err)
-		return
-	}
-
-	oldTasks := []Task{}
-	state.DB().Where("refresh_time < ?", refreshTime).Find(&oldTasks)
-	state.log.Debug(fmt.Sprintf("Found %d old Tasks", len(oldTasks)))
-	for _, oldTask := range oldTasks {
-		state.DB().Delete(&oldTask)
+		return err
 	}
+	state.reportChanges(changes)
+	return nil
 }
 
-// Creates a Task model to be used in a gorm Assign() call
-func (state *State) taskAssignment(task *ecs.Task) Task {
-	assignment := Task{
-		ClusterARN:           *task.ClusterArn,
-		ContainerInstanceARN: *task.ContainerInstanceArn,
-		TaskDefinitionARN:    *task.TaskDefinitionArn,
-		DesiredStatus:        *task.DesiredStatus,
-		LastStatus:           *task.DesiredStatus,
-	}
-	if task.StartedBy != nil {
-		assignment.StartedBy = *task.StartedBy
-	}
-	return assignment
+// Lists and Describes the services in the cluster and stores them, along with their deployments, locally.  Services
+// are not refreshed by RefreshAll, so call this when service information is needed.
+func (state *State) RefreshServiceState() error {
+	state.log.Info("entering RefreshServiceState()")
+	return state.refreshFlight.do(RefreshKindServices, state.refreshServices)
 }
 
-// Unpack a list of ECS resources to retrieve a single resources value as a string, for example the CPU remaining a Container Instance.
-func (state *State) getResourceAsInt(resources []*ecs.Resource, name string, defaultValue int) int {
-	for _, resource := range resources {
-		if *resource.Name == name && *resource.Type == "INTEGER" {
-			return int(*resource.IntegerValue)
-		}
-	}
+// Refreshes Services on behalf of RefreshServiceState.
+func (state *State) refreshServices() (err error) {
+	start := state.now()
+	count := 0
+	defer func() { state.finishRefresh(RefreshKindServices, start, count, err) }()
 
-	return defaultValue
-}
+	params := &ecs.ListServicesInput{
+		Cluster: aws.String(state.clusterName),
+	}
 
-// Unpack a list of ECS resources to retrieve the ports still available on a Container Instance
-func (state *State) getResourceAsPortSet(resources []*ecs.Resource, name string, defaultValue string) string {
-	for _, resource := range resources {
-		if *resource.Name == name && *resource.Type == "STRINGSET" {
-			return state.portStringBuilder(resource.StringSetValue)
+	described := []*ecs.Service{}
+	var describeErr error
+	err = state.ecs_client.ListServicesPages(params, func(page *ecs.ListServicesOutput, lastPage bool) bool {
+		if len(page.ServiceArns) == 0 {
+			return !lastPage
+		}
+		params := &ecs.DescribeServicesInput{
+			Services: page.ServiceArns,
+			Cluster:  aws.String(state.clusterName),
 		}
+		resp, err := state.ecs_client.DescribeServices(params)
+		if err != nil {
+			describeErr = state.handleAwsError(err)
+			return false
+		}
+
+		state.handleFailures(resp.Failures)
+		described = append(described, resp.Services...)
+		return !lastPage
+	})
+	if err != nil {
+		return state.handleAwsError(err)
+	}
+	if describeErr != nil {
+		return describeErr
 	}
 
-	return defaultValue
-}
+	refreshTime := int(state.now().Unix())
+	_, err = state.applyRefresh(func(tx *gorm.DB) ([]StateChange, error) {
+		for _, service := range described {
+			if service.ServiceArn == nil {
+				state.log.Warn("Skipping Service without an ARN:", service)
+				continue
+			}
+			count++
+			serviceModel := Service{}
+			assignment := state.serviceAssignment(service)
+			assignment.RefreshTime = refreshTime
+			deployments := assignment.Deployments
+			assignment.Deployments = nil
+			taskSets := assignment.TaskSets
+			assignment.TaskSets = nil
+			err := tx.Where("a_r_n = ?", *service.ServiceArn).Attrs(Service{ARN: *service.ServiceArn}).Assign(assignment).FirstOrCreate(&serviceModel).Error
+			if err != nil {
+				return nil, err
+			}
+			// Written as columns directly since a struct Assign() skips zero values.
+			err = tx.Model(&serviceModel).UpdateColumns(map[string]interface{}{
+				"desired_count": assignment.DesiredCount,
+				"running_count": assignment.RunningCount,
+				"pending_count": assignment.PendingCount,
+			}).Error
+			if err != nil {
+				return nil, err
+			}
 
-// A searchable string representation of ports in use to allow for queries of local state with port constraints.
-func (state *State) portStringBuilder(ports []*string) string {
-	var buffer bytes.Buffer
-	for _, port := range ports {
-		buffer.WriteString(fmt.Sprintf("=%s=", *port))
-	}
+			// Only current deployments are returned by ECS, so they are replaced wholesale.
+			if err := tx.Where("service_a_r_n = ?", serviceModel.ARN).Delete(Deployment{}).Error; err != nil {
+				return nil, err
+			}
+			for _, deployment := range deployments {
+				if err := tx.Create(&deployment).Error; err != nil {
+					return nil, err
+				}
+			}
+			if err := tx.Where("service_a_r_n = ?", serviceModel.ARN).Delete(TaskSet{}).Error; err != nil {
+				return nil, err
+			}
+			for _, taskSet := range taskSets {
+				if err := tx.Create(&taskSet).Error; err != nil {
+					return nil, err
+				}
+			}
+			state.log.Debug(fmt.Sprintf("Refreshed Service: %+v", service))
+		}
 
-	return buffer.String()
+		oldServices := []Service{}
+		if err := tx.Where("refresh_time < ?", state.staleCutoff(refreshTime)).Find(&oldServices).Error; err != nil {
+			return nil, err
+		}
+		state.log.Debug(fmt.Sprintf("Found %d old Services", len(oldServices)))
+		for _, oldService := range oldServices {
+			if err := tx.Where("service_a_r_n = ?", oldService.ARN).Delete(Deployment{}).Error; err != nil {
+				return nil, err
+			}
+			if err := tx.Where("service_a_r_n = ?", oldService.ARN).Delete(TaskSet{}).Error; err != nil {
+				return nil, err
+			}
+			if err := tx.Delete(&oldService).Error; err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
 }
 
-// Creates a ContainerInstance model to be used in a gorm Assign() call
-func (state *State) containerInstanceAssignment(cluster Cluster, containerInstance *ecs.ContainerInstance) ContainerInstance {
-	assignment := ContainerInstance{ClusterARN: cluster.ARN}
-	if containerInstance.AgentConnected != nil {
-		assignment.AgentConnected = *containerInstance.AgentConnected
+// Creates a Service model, including its Deployments, to be used in a gorm Assign() call
+func (state *State) serviceAssignment(service *ecs.Service) Service {
+	assignment := Service{
+		Name:              aws.StringValue(service.ServiceName),
+		ClusterARN:        aws.StringValue(service.ClusterArn),
+		Status:            aws.StringValue(service.Status),
+		TaskDefinitionARN: aws.StringValue(service.TaskDefinition),
+		LaunchType:        aws.StringValue(service.LaunchType),
+		DesiredCount:      int(aws.Int64Value(service.DesiredCount)),
+		RunningCount:      int(aws.Int64Value(service.RunningCount)),
+		PendingCount:      int(aws.Int64Value(service.PendingCount)),
 	}
-	if containerInstance.VersionInfo != nil {
-		vi := containerInstance.VersionInfo
-		if vi.AgentHash != nil {
-			assignment.AgentHash = *vi.AgentHash
+	for _, deployment := range service.Deployments {
+		if deployment == nil || deployment.Id == nil {
+			continue
 		}
-		if vi.AgentVersion != nil {
-			assignment.AgentVersion = *vi.AgentVersion
+		deploymentModel := Deployment{
+			ID:                *deployment.Id,
+			ServiceARN:        *service.ServiceArn,
+			Status:            aws.StringValue(deployment.Status),
+			TaskDefinitionARN: aws.StringValue(deployment.TaskDefinition),
+			DesiredCount:      int(aws.Int64Value(deployment.DesiredCount)),
+			RunningCount:      int(aws.Int64Value(deployment.RunningCount)),
+			PendingCount:      int(aws.Int64Value(deployment.PendingCount)),
+			RolloutState:      aws.StringValue(deployment.RolloutState),
 		}
-		if vi.DockerVersion != nil {
-			assignment.DockerVersion = *vi.DockerVersion
+		if deployment.CreatedAt != nil {
+			deploymentModel.CreatedAtUnix = int(deployment.CreatedAt.Unix())
 		}
+		assignment.Deployments = append(assignment.Deployments, deploymentModel)
 	}
-	if containerInstance.AgentUpdateStatus != nil {
-		assignment.AgentUpdateStatus = *containerInstance.AgentUpdateStatus
-	}
-	if containerInstance.Ec2InstanceId != nil {
-		assignment.EC2InstanceId = *containerInstance.Ec2InstanceId
-	}
-	if containerInstance.RegisteredResources != nil {
-		assignment.RegisteredCPU = state.getResourceAsInt(containerInstance.RegisteredResources, "CPU", 0)
-		assignment.RegisteredMemory = state.getResourceAsInt(containerInstance.RegisteredResources, "MEMORY", 0)
-		assignment.RegisteredTCPPorts = state.getResourceAsPortSet(containerInstance.RegisteredResources, "PORTS", "")
-		assignment.RegisteredUDPPorts = state.getResourceAsPortSet(containerInstance.RegisteredResources, "PORTS_UDP", "")
-	}
-	if containerInstance.RemainingResources != nil {
-		assignment.RemainingCPU = state.getResourceAsInt(containerInstance.RemainingResources, "CPU", 0)
-		assignment.RemainingMemory = state.getResourceAsInt(containerInstance.RemainingResources, "MEMORY", 0)
-		assignment.RemainingTCPPorts = state.getResourceAsPortSet(containerInstance.RemainingResources, "PORTS", "")
-		assignment.RemainingUDPPorts = state.getResourceAsPortSet(containerInstance.RemainingResources, "PORTS_UDP", "")
-	}
-	if containerInstance.Status != nil {
-		assignment.Status = *containerInstance.Status
+	for _, taskSet := range service.TaskSets {
+		if taskSet == nil || taskSet.Id == nil {
+			continue
+		}
+		taskSetModel := TaskSet{
+			ID:                   *taskSet.Id,
+			ARN:                  aws.StringValue(taskSet.TaskSetArn),
+			ServiceARN:           *service.ServiceArn,
+			Status:               aws.StringValue(taskSet.Status),
+			TaskDefinitionARN:    aws.StringValue(taskSet.TaskDefinition),
+			ExternalID:           aws.StringValue(taskSet.ExternalId),
+			ComputedDesiredCount: int(aws.Int64Value(taskSet.ComputedDesiredCount)),
+			RunningCount:         int(aws.Int64Value(taskSet.RunningCount)),
+			PendingCount:         int(aws.Int64Value(taskSet.PendingCount)),
+			StabilityStatus:      aws.StringValue(taskSet.StabilityStatus),
+		}
+		if taskSet.CreatedAt != nil {
+			taskSetModel.CreatedAtUnix = int(taskSet.CreatedAt.Unix())
+		}
+		assignment.TaskSets = append(assignment.TaskSets, taskSetModel)
 	}
 	return assignment
 }
 
-// Load the cluster and all ContainerInstances and Tasks into memory as Go objects.
-func (state *State) FindClusterByName(name string) Cluster {
-	state.log.Info("entering FindClusterByName()")
-	cluster := Cluster{}
-	state.DB().Where("name = ?", name).Preload("ContainerInstances").Preload("Tasks").Preload("ContainerInstances.Tasks").First(&cluster)
-	return cluster
+// Returns the Service with the given name, along with its Deployments, or ErrServiceNotFound if it is not in local
+// state.  RefreshServiceState must have been called for any Services to be found.
+func (state *State) FindServiceByName(name string) (Service, error) {
+	state.log.Info("entering FindServiceByName()")
+	service := Service{}
+	query := state.DB().Where("name = ?", name).Preload("Deployments").Preload("TaskSets").First(&service)
+	if query.RecordNotFound() {
+		return Service{}, ErrServiceNotFound
+	}
+	return service, query.Error
 }
 
-// Resolve and cache locally a Task Definition from either a short string like my_app:1 or a full ARN.
-func (state *State) FindTaskDefinition(td string) TaskDefinition {
-	state.log.Info("entering FindTaskDefinition()")
-	queryString := "short_string = ?"
-	if strings.HasPrefix(td, "arn:aws:ecs:") {
-		queryString = "a_r_n = ?"
+// Returns the Tasks started by the named service, linked through their "service:<name>" Group.
+func (state *State) FindTasksByService(name string) ([]Task, error) {
+	state.log.Info("entering FindTasksByService()")
+	tasks := []Task{}
+	err := state.DB().Where("\"group\" = ?", "service:"+name).Find(&tasks).Error
+	return tasks, err
+}
+
+// Returns the Tasks belonging to the task set with the given ID, such as ecs-svc/1234567890, linked through their
+// StartedBy, for example to watch the tasks of a canary during a blue/green deployment.
+func (state *State) FindTasksByTaskSet(id string) ([]Task, error) {
+	state.log.Info("entering FindTasksByTaskSet()")
+	tasks := []Task{}
+	err := state.DB().Where("started_by = ?", id).Find(&tasks).Error
+	return tasks, err
+}
+
+// Reports the progress of the named service's deployment from the Tasks in local state, after RefreshServiceState and
+// RefreshTaskState.  Returns ErrServiceNotFound if the service is not in local state.
+func (state *State) DeploymentProgress(serviceName string) (DeploymentStatus, error) {
+	state.log.Info("entering DeploymentProgress()")
+	service, err := state.FindServiceByName(serviceName)
+	if err != nil {
+		return DeploymentStatus{}, err
 	}
 
-	state.log.Debug("Query prefix is:", queryString)
+	status := DeploymentStatus{TaskDefinitionARN: service.TaskDefinitionARN, DesiredCount: service.DesiredCount}
+	group := "service:" + serviceName
+	err = state.DB().Model(&Task{}).Where("\"group\" = ? AND task_definition_a_r_n = ? AND last_status = ?",
+		group, service.TaskDefinitionARN, ecs.DesiredStatusRunning).Count(&status.RunningCount).Error
+	if err != nil {
+		return status, err
+	}
+	err = state.DB().Model(&Task{}).Where("\"group\" = ? AND task_definition_a_r_n <> ? AND last_status <> ?",
+		group, service.TaskDefinitionARN, ecs.DesiredStatusStopped).Count(&status.OldTasksRemaining).Error
+	if err != nil {
+		return status, err
+	}
+
+	status.Complete = status.RunningCount == status.DesiredCount && status.OldTasksRemaining == 0
+	return status, nil
+}
+
+// Counts, per TaskDefinition ARN, the Tasks in local state that ECS wants RUNNING and those that are RUNNING, whether
+// or not they belong to a service, for example to tell when a new revision has fully rolled out.  TaskDefinitions
+// with neither are omitted.  RefreshTaskState should be called first.
+func (state *State) TaskDefinitionRolloutCounts() (map[string]RolloutCount, error) {
+	state.log.Info("entering TaskDefinitionRolloutCounts()")
+	counts := map[string]RolloutCount{}
+	rows, err := state.DB().Model(&Task{}).
+		Select("task_definition_a_r_n, SUM(CASE WHEN desired_status = ? THEN 1 ELSE 0 END), SUM(CASE WHEN last_status = ? THEN 1 ELSE 0 END)",
+			ecs.DesiredStatusRunning, ecs.DesiredStatusRunning).
+		Where("desired_status = ? OR last_status = ?", ecs.DesiredStatusRunning, ecs.DesiredStatusRunning).
+		Group("task_definition_a_r_n").Rows()
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var arn string
+		count := RolloutCount{}
+		if err := rows.Scan(&arn, &count.Desired, &count.Running); err != nil {
+			return counts, err
+		}
+		counts[arn] = count
+	}
+	return counts, rows.Err()
+}
+
+// The refresh time before which unseen records are removed, allowing for the configured StaleRecordTTL.
+func (state *State) staleCutoff(refreshTime int) int {
+	return refreshTime - int(state.options.StaleRecordTTL.Seconds())
+}
+
+// Refreshes the cluster, its ContainerInstances, and its Tasks in dependency order.  Every refresh is attempted
+// even if an earlier one fails, and any errors are returned together as RefreshErrors.
+func (state *State) RefreshAll() error {
+	state.log.Info("entering RefreshAll()")
+	errs := RefreshErrors{}
+	if err := state.RefreshClusterState(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := state.RefreshTaskState(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Records when a ContainerInstance's agent first disconnected, clearing it again once the agent reconnects.
+// Written as columns directly since a struct Assign() skips false and zero values.
+func (state *State) trackAgentConnection(db *gorm.DB, containerInstance ContainerInstance, agentConnected bool, refreshTime int) error {
+	disconnectedSince := 0
+	if !agentConnected {
+		disconnectedSince = containerInstance.DisconnectedSince
+		if disconnectedSince == 0 {
+			disconnectedSince = refreshTime
+		}
+	}
+	return db.Model(&containerInstance).UpdateColumns(map[string]interface{}{
+		"agent_connected":    agentConnected,
+		"disconnected_since": disconnectedSince,
+	}).Error
+}
+
+// Returns the ContainerInstances running an ECS agent older than version, such as "1.51.0", for example to drain and
+// replace instances on outdated agents.  Versions are compared numerically by component, so 1.9.0 is older than
+// 1.10.0.  Instances whose agent version is unknown are not returned.
+func (state *State) FindInstancesWithAgentOlderThan(version string) ([]ContainerInstance, error) {
+	state.log.Info("entering FindInstancesWithAgentOlderThan()")
+	outdated := []ContainerInstance{}
+	minimum, err := parseAgentVersion(version)
+	if err != nil {
+		return outdated, err
+	}
+
+	containerInstances := []ContainerInstance{}
+	if err := state.DB().Where("agent_version <> ''").Find(&containerInstances).Error; err != nil {
+		return outdated, err
+	}
+	for _, containerInstance := range containerInstances {
+		current, err := parseAgentVersion(containerInstance.AgentVersion)
+		if err != nil {
+			state.log.Warn("Ignoring unparseable agent version of", containerInstance.ARN, err)
+			continue
+		}
+		if compareAgentVersions(current, minimum) < 0 {
+			outdated = append(outdated, containerInstance)
+		}
+	}
+	return outdated, nil
+}
+
+// Parses an agent version such as "1.51.0" or "v1.51.0" into its numeric components.  Any pre-release or build
+// suffix, as in "1.51.0-beta", is ignored.
+func parseAgentVersion(version string) ([]int, error) {
+	trimmed := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(trimmed, "-+ "); i >= 0 {
+		trimmed = trimmed[:i]
+	}
+	components := []int{}
+	for _, part := range strings.Split(trimmed, ".") {
+		component, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("ecs_state: invalid agent version %q", version)
+		}
+		components = append(components, component)
+	}
+	return components, nil
+}
+
+// Returns a negative number if version a is older than b, zero if they are equal, and a positive number if a is newer.
+// Missing trailing components count as zero, so 1.5 equals 1.5.0.
+func compareAgentVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// Returns the ContainerInstances whose agent has been disconnected for at least olderThan, for example to
+// decide which instances to drain and replace.
+func (state *State) FindDisconnectedInstances(olderThan time.Duration) ([]ContainerInstance, error) {
+	state.log.Info("entering FindDisconnectedInstances()")
+	cutoff := int(state.now().Add(-olderThan).Unix())
+	containerInstances := []ContainerInstance{}
+	err := state.DB().Where("agent_connected = ? AND disconnected_since > 0 AND disconnected_since <= ?", false, cutoff).Find(&containerInstances).Error
+	return containerInstances, err
+}
+
+// Recomputes the CPU and memory remaining on a ContainerInstance from its registered resources and the Tasks on it.
+// Differences from the RemainingCPU and RemainingMemory reported by ECS indicate lagging state.
+func (state *State) ComputeRemaining(instanceARN string) (cpu, memory int, err error) {
+	state.log.Info("entering ComputeRemaining()")
+	containerInstance := ContainerInstance{}
+	query := state.DB().Where("a_r_n = ?", instanceARN).First(&containerInstance)
+	if query.RecordNotFound() {
+		return 0, 0, ErrContainerInstanceNotFound
+	} else if query.Error != nil {
+		return 0, 0, query.Error
+	}
+
+	tasks := []Task{}
+	err = state.DB().Where("container_instance_a_r_n = ? AND last_status <> ?", instanceARN, ecs.DesiredStatusStopped).
+		Preload("ContainerOverrides").Find(&tasks).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	tds := []string{}
+	for _, task := range tasks {
+		tds = append(tds, task.TaskDefinitionARN)
+	}
+	taskDefinitions, err := state.resolveTaskDefinitions(tds)
+	if err != nil {
+		return 0, 0, err
+	}
+	// Containers are needed to apply container overrides, and are not loaded with cached TaskDefinitions.
+	for td, taskDefinition := range taskDefinitions {
+		if err := state.DB().Where("task_definition_a_r_n = ?", taskDefinition.ARN).Find(&taskDefinition.ContainerDefinitions).Error; err != nil {
+			return 0, 0, err
+		}
+		taskDefinitions[td] = taskDefinition
+	}
+
+	cpu, memory = containerInstance.RegisteredCPU, containerInstance.RegisteredMemory
+	for _, task := range tasks {
+		taskCpu, taskMemory := task.ResourceRequirements(taskDefinitions[task.TaskDefinitionARN])
+		cpu -= taskCpu
+		memory -= taskMemory
+	}
+	state.log.Debug(fmt.Sprintf("Computed remaining cpu %d and memory %d for %s, ECS reports cpu %d and memory %d",
+		cpu, memory, instanceARN, containerInstance.RemainingCPU, containerInstance.RemainingMemory))
+	return cpu, memory, nil
+}
+
+// Returns how many TCP and UDP ports of the dynamic port range are still free on a ContainerInstance, being neither
+// reserved as reported by ECS nor bound by its Tasks, so that dynamic port capacity can be reasoned about without
+// parsing the internal port format.  Returns ErrContainerInstanceNotFound if the instance is not in local state.
+func (state *State) RemainingPortCount(instanceARN string) (freeTCP, freeUDP int, err error) {
+	state.log.Info("entering RemainingPortCount()")
+	containerInstance := ContainerInstance{}
+	query := state.DB().Where("a_r_n = ?", instanceARN).First(&containerInstance)
+	if query.RecordNotFound() {
+		return 0, 0, ErrContainerInstanceNotFound
+	} else if query.Error != nil {
+		return 0, 0, query.Error
+	}
+	return containerInstance.FreeDynamicTCPPorts, containerInstance.FreeDynamicUDPPorts, nil
+}
+
+// Returns the ContainerInstance running on the given EC2 instance, for example to check for running Tasks before
+// approving an Auto Scaling termination.  Returns ErrContainerInstanceNotFound if there is none.
+func (state *State) FindInstanceByEC2Id(id string) (ContainerInstance, error) {
+	state.log.Info("entering FindInstanceByEC2Id()")
+	containerInstance := ContainerInstance{}
+	query := state.DB().Where("ec2_instance_id = ?", id).First(&containerInstance)
+	if query.RecordNotFound() {
+		return ContainerInstance{}, ErrContainerInstanceNotFound
+	}
+	return containerInstance, query.Error
+}
+
+// Returns the number of ContainerInstances in each Status, such as ACTIVE or DRAINING, counted in the database.
+func (state *State) InstanceStatusCounts() (map[string]int, error) {
+	state.log.Info("entering InstanceStatusCounts()")
+	counts := map[string]int{}
+	rows, err := state.DB().Model(&ContainerInstance{}).Select("status, count(*)").Group("status").Rows()
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return counts, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+// Returns the number of ContainerInstances whose agent is connected and disconnected, counted in the database.
+func (state *State) AgentConnectionCounts() (connected, disconnected int, err error) {
+	state.log.Info("entering AgentConnectionCounts()")
+	rows, err := state.DB().Model(&ContainerInstance{}).Select("agent_connected, count(*)").Group("agent_connected").Rows()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var agentConnected bool
+		var count int
+		if err := rows.Scan(&agentConnected, &count); err != nil {
+			return 0, 0, err
+		}
+		if agentConnected {
+			connected = count
+		} else {
+			disconnected = count
+		}
+	}
+	return connected, disconnected, rows.Err()
+}
+
+// Returns the ContainerInstances whose health checks report them as IMPAIRED, for example to drain them before they
+// fail Tasks.  Health is only known when CONTAINER_INSTANCE_HEALTH is in Options.ContainerInstanceInclude.
+func (state *State) FindUnhealthyInstances() ([]ContainerInstance, error) {
+	state.log.Info("entering FindUnhealthyInstances()")
+	containerInstances := []ContainerInstance{}
+	err := state.DB().Where("health_status = ?", ecs.InstanceHealthCheckStateImpaired).Find(&containerInstances).Error
+	return containerInstances, err
+}
+
+// Returns the ContainerInstances running on the given EC2 instance type, such as m5.large.
+func (state *State) FindInstancesByType(instanceType string) ([]ContainerInstance, error) {
+	state.log.Info("entering FindInstancesByType()")
+	containerInstances := []ContainerInstance{}
+	err := state.DB().Where("instance_type = ?", instanceType).Find(&containerInstances).Error
+	return containerInstances, err
+}
+
+// Returns the ContainerInstances with less than pct percent of their registered memory remaining, for example to
+// find memory pressure across instances of different sizes.  Instances without registered memory are never returned.
+func (state *State) FindInstancesBelowMemoryPercent(pct float64) ([]ContainerInstance, error) {
+	state.log.Info("entering FindInstancesBelowMemoryPercent()")
+	containerInstances := []ContainerInstance{}
+	err := state.DB().Where("registered_memory > 0 AND remaining_memory * 100.0 / registered_memory < ?", pct).Find(&containerInstances).Error
+	return containerInstances, err
+}
+
+// Returns the ContainerInstances that have no Tasks other than STOPPED ones, for example as candidates for scale-in.
+func (state *State) FindIdleInstances() ([]ContainerInstance, error) {
+	state.log.Info("entering FindIdleInstances()")
+	containerInstances := []ContainerInstance{}
+	instances, tasks := state.tableName(ContainerInstance{}), state.tableName(Task{})
+	err := state.DB().
+		Select(instances + ".*").
+		Joins(fmt.Sprintf("LEFT JOIN %s ON %s.container_instance_a_r_n = %s.a_r_n AND %s.last_status <> 'STOPPED'", tasks, tasks, instances, tasks)).
+		Where(tasks + ".a_r_n IS NULL").
+		Find(&containerInstances).Error
+	return containerInstances, err
+}
+
+// Creates a Task model to be used in a gorm Assign() call.  Any field may be absent, for example a PENDING
+// Task that has not been placed yet has no ContainerInstanceArn.
+func (state *State) taskAssignment(task *ecs.Task) Task {
+	assignment := Task{}
+	if task.ClusterArn != nil {
+		assignment.ClusterARN = *task.ClusterArn
+	}
+	if task.ContainerInstanceArn != nil {
+		assignment.ContainerInstanceARN = *task.ContainerInstanceArn
+	}
+	if task.TaskDefinitionArn != nil {
+		assignment.TaskDefinitionARN = *task.TaskDefinitionArn
+	}
+	if task.DesiredStatus != nil {
+		assignment.DesiredStatus = *task.DesiredStatus
+	}
+	if task.LastStatus != nil {
+		assignment.LastStatus = *task.LastStatus
+	}
+	if task.StartedBy != nil {
+		assignment.StartedBy = *task.StartedBy
+	}
+	if task.Group != nil {
+		assignment.Group = *task.Group
+	}
+	if task.Connectivity != nil {
+		assignment.Connectivity = *task.Connectivity
+	}
+	if task.HealthStatus != nil {
+		assignment.HealthStatus = *task.HealthStatus
+	}
+	if task.StoppedReason != nil {
+		assignment.StoppedReason = *task.StoppedReason
+	}
+	if task.StopCode != nil {
+		assignment.StopCode = *task.StopCode
+	}
+	if task.StartedAt != nil {
+		assignment.StartedAt = int(task.StartedAt.Unix())
+	}
+	if task.StoppedAt != nil {
+		assignment.StoppedAt = int(task.StoppedAt.Unix())
+	}
+	if task.Overrides != nil {
+		if cpu, ok := state.getTaskResourceAsInt(task.Overrides.Cpu, "vcpu"); ok {
+			assignment.OverrideCpu = cpu
+		}
+		if memory, ok := state.getTaskResourceAsInt(task.Overrides.Memory, "gb"); ok {
+			assignment.OverrideMemory = memory
+		}
+		for _, containerOverride := range task.Overrides.ContainerOverrides {
+			if containerOverride == nil || containerOverride.Name == nil || task.TaskArn == nil {
+				continue
+			}
+			override := ContainerOverride{TaskARN: *task.TaskArn, Name: *containerOverride.Name}
+			override.Cpu = int(aws.Int64Value(containerOverride.Cpu))
+			// As for ContainerDefinitions, the hard memory limit is used when set, otherwise the soft limit.
+			override.Memory = int(aws.Int64Value(containerOverride.Memory))
+			if override.Memory == 0 {
+				override.Memory = int(aws.Int64Value(containerOverride.MemoryReservation))
+			}
+			if override.Cpu > 0 || override.Memory > 0 {
+				assignment.ContainerOverrides = append(assignment.ContainerOverrides, override)
+			}
+		}
+	}
+	for _, container := range task.Containers {
+		for _, binding := range container.NetworkBindings {
+			if binding == nil || binding.HostPort == nil {
+				continue
+			}
+			port := fmt.Sprintf("=%d=", *binding.HostPort)
+			if aws.StringValue(binding.Protocol) == ecs.TransportProtocolUdp {
+				assignment.UDPPorts += port
+			} else {
+				assignment.TCPPorts += port
+			}
+		}
+	}
+	return assignment
+}
+
+// Returns the Tasks belonging to the cluster with the given ARN.
+func (state *State) FindTasksByCluster(clusterARN string) ([]Task, error) {
+	state.log.Info("entering FindTasksByCluster()")
+	tasks := []Task{}
+	err := state.DB().Where("cluster_a_r_n = ?", clusterARN).Find(&tasks).Error
+	return tasks, err
+}
+
+// Returns the Tasks using a TaskDefinition, given as a short string like my_app:1 or a full ARN and resolved as
+// FindTaskDefinition does, for example to count replicas during a deployment.  Returns the error from ECS if the
+// definition is not cached and cannot be described.
+func (state *State) FindTasksByTaskDefinition(td string) ([]Task, error) {
+	state.log.Info("entering FindTasksByTaskDefinition()")
+	tasks := []Task{}
+	taskDefinition, found := state.cachedTaskDefinition(td)
+	if !found {
+		refreshed, err := state.RefreshTaskDefinition(td)
+		if err != nil {
+			return tasks, err
+		}
+		taskDefinition = refreshed
+	}
+
+	err := state.DB().Where("task_definition_a_r_n = ?", taskDefinition.ARN).Find(&tasks).Error
+	return tasks, err
+}
+
+// Returns the Tasks tagged with the given key and value.  Tags are only stored when Options.IncludeTags is set.
+func (state *State) FindTasksByTag(key, value string) ([]Task, error) {
+	state.log.Info("entering FindTasksByTag()")
+	tasks := []Task{}
+	err := state.DB().Where(fmt.Sprintf("a_r_n IN (SELECT resource_a_r_n FROM %s WHERE key = ? AND value = ?)", state.tableName(Tag{})),
+		key, value).Find(&tasks).Error
+	return tasks, err
+}
+
+// Returns the Tasks placed on a ContainerInstance that is not in local state, which suggests refreshes ran out of
+// order or the instance was removed before its Tasks were swept.  Tasks not yet placed on an instance are never
+// returned.  Use RemoveTask to clean them up.
+func (state *State) FindOrphanedTasks() ([]Task, error) {
+	state.log.Info("entering FindOrphanedTasks()")
+	tasks := []Task{}
+	err := state.DB().Where(fmt.Sprintf("container_instance_a_r_n <> '' AND container_instance_a_r_n NOT IN (SELECT a_r_n FROM %s)",
+		state.tableName(ContainerInstance{}))).Find(&tasks).Error
+	return tasks, err
+}
+
+// Returns the Tasks whose health checks report them as UNHEALTHY.
+func (state *State) FindUnhealthyTasks() ([]Task, error) {
+	state.log.Info("entering FindUnhealthyTasks()")
+	tasks := []Task{}
+	err := state.DB().Where("health_status = ?", ecs.HealthStatusUnhealthy).Find(&tasks).Error
+	return tasks, err
+}
+
+// Returns the Tasks seen as STOPPED which stopped within the given duration, along with their StoppedReason and
+// StopCode.  Configure Options.StoppedTaskRetention to keep stopped Tasks around long enough to be found.
+func (state *State) FindRecentlyStoppedTasks(within time.Duration) ([]Task, error) {
+	state.log.Info("entering FindRecentlyStoppedTasks()")
+	since := int(state.now().Add(-within).Unix())
+	tasks := []Task{}
+	err := state.DB().Where("last_status = ? AND stopped_at >= ?", ecs.DesiredStatusStopped, since).Find(&tasks).Error
+	return tasks, err
+}
+
+// Returns the Tasks ECS intends to stop, for example during a deployment or scale-in, which have not stopped yet.
+func (state *State) FindTasksDesiredStopped() ([]Task, error) {
+	state.log.Info("entering FindTasksDesiredStopped()")
+	tasks := []Task{}
+	err := state.DB().Where("desired_status = ? AND last_status <> ?", ecs.DesiredStatusStopped, ecs.DesiredStatusStopped).Find(&tasks).Error
+	return tasks, err
+}
+
+// Unpack a list of ECS resources to retrieve a single resources value as a string, for example the CPU remaining a Container Instance.
+func (state *State) getResourceAsInt(resources []*ecs.Resource, name string, defaultValue int) int {
+	for _, resource := range resources {
+		if resource != nil && aws.StringValue(resource.Name) == name && aws.StringValue(resource.Type) == "INTEGER" && resource.IntegerValue != nil {
+			return int(*resource.IntegerValue)
+		}
+	}
+
+	return defaultValue
+}
+
+// The configured DynamicPortRange, or the default if none is configured.
+func (state *State) dynamicPortRange() PortRange {
+	if state.options.DynamicPortRange == (PortRange{}) {
+		return defaultDynamicPortRange
+	}
+	return state.options.DynamicPortRange
+}
+
+// Returns how many ports of the dynamic port range are not listed among any of the encoded ports in use.
+func (state *State) freeDynamicPorts(encoded ...string) int {
+	portRange := state.dynamicPortRange()
+	used := map[int]bool{}
+	for _, ports := range encoded {
+		for _, port := range ParsePorts(ports) {
+			if port >= portRange.Low && port <= portRange.High {
+				used[port] = true
+			}
+		}
+	}
+	free := portRange.High - portRange.Low + 1 - len(used)
+	if free < 0 {
+		return 0
+	}
+	return free
+}
+
+// Returns how many of the comma separated ports of a TaskDefinition fall within the dynamic port range, each of which
+// uses up a port that could otherwise have been bound dynamically.
+func (state *State) fixedDynamicPorts(ports string) int {
+	portRange := state.dynamicPortRange()
+	count := 0
+	for _, port := range strings.Split(ports, ",") {
+		if value, err := strconv.Atoi(port); err == nil && value >= portRange.Low && value <= portRange.High {
+			count++
+		}
+	}
+	return count
+}
+
+// Sets the free dynamic ports of a ContainerInstance assignment from the ports ECS reports as reserved on it along with
+// those bound by its Tasks, since ECS leaves out the ports chosen for dynamic host port mappings.  The db is provided so
+// that the Tasks can be read within a transaction.
+func (state *State) countFreeDynamicPorts(db *gorm.DB, assignment *ContainerInstance, arn string) error {
+	tasks := []Task{}
+	if err := db.Where("container_instance_a_r_n = ? AND last_status <> ?", arn, ecs.DesiredStatusStopped).Find(&tasks).Error; err != nil {
+		return err
+	}
+	tcpPorts, udpPorts := []string{assignment.RemainingTCPPorts}, []string{assignment.RemainingUDPPorts}
+	for _, task := range tasks {
+		tcpPorts = append(tcpPorts, task.TCPPorts)
+		udpPorts = append(udpPorts, task.UDPPorts)
+	}
+	assignment.FreeDynamicTCPPorts = state.freeDynamicPorts(tcpPorts...)
+	assignment.FreeDynamicUDPPorts = state.freeDynamicPorts(udpPorts...)
+	return nil
+}
+
+// Counts the free dynamic ports of every ContainerInstance again once the Tasks bound to them have been refreshed,
+// still deducting the dynamic ports of outstanding reservations.  Callers must hold the reservationLock.
+func (state *State) recountFreeDynamicPorts(db *gorm.DB) error {
+	containerInstances := []ContainerInstance{}
+	if err := db.Find(&containerInstances).Error; err != nil {
+		return err
+	}
+	for _, containerInstance := range containerInstances {
+		updated := containerInstance
+		if err := state.countFreeDynamicPorts(db, &updated, containerInstance.ARN); err != nil {
+			return err
+		}
+		for _, reservation := range state.reservations {
+			if reservation.ContainerInstanceARN == containerInstance.ARN {
+				updated.FreeDynamicTCPPorts -= reservation.DynamicTCPPorts
+				updated.FreeDynamicUDPPorts -= reservation.DynamicUDPPorts
+			}
+		}
+		err := db.Model(&containerInstance).UpdateColumns(map[string]interface{}{
+			"free_dynamic_tcp_ports": updated.FreeDynamicTCPPorts,
+			"free_dynamic_udp_ports": updated.FreeDynamicUDPPorts,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Marks a remaining resource ECS did not report in a ContainerInstance assignment.
+const missingResource = -1
+
+// Replaces the remaining CPU or memory of a ContainerInstance assignment that ECS did not report, according to
+// Options.MissingResources.
+func (state *State) resolveMissingResources(db *gorm.DB, assignment *ContainerInstance, arn string) error {
+	if assignment.RemainingCPU != missingResource && assignment.RemainingMemory != missingResource {
+		return nil
+	}
+	state.log.Warn("ECS did not report the remaining resources of ContainerInstance", arn)
+
+	previous := ContainerInstance{}
+	if state.options.MissingResources == ResourceFallbackLastKnown {
+		if query := db.Where("a_r_n = ?", arn).First(&previous); query.Error != nil && !query.RecordNotFound() {
+			return query.Error
+		}
+	}
+	resolve := func(remaining *int, registered int, lastKnown int) {
+		if *remaining != missingResource {
+			return
+		}
+		switch state.options.MissingResources {
+		case ResourceFallbackRegistered:
+			*remaining = registered
+		case ResourceFallbackLastKnown:
+			*remaining = lastKnown
+		default:
+			*remaining = 0
+		}
+	}
+	resolve(&assignment.RemainingCPU, assignment.RegisteredCPU, previous.RemainingCPU)
+	resolve(&assignment.RemainingMemory, assignment.RegisteredMemory, previous.RemainingMemory)
+	return nil
+}
+
+// Parse a task level CPU or memory value.  ECS accepts these either in units, like 1024, or with a unit suffix,
+// like "1 vCPU" or "2 GB", where one vCPU or GB is 1024 units.  Returns false if the value is absent or unparseable.
+func (state *State) getTaskResourceAsInt(value *string, unit string) (int, bool) {
+	if value == nil {
+		return 0, false
+	}
+
+	trimmed := strings.TrimSpace(strings.ToLower(*value))
+	multiplier := 1.0
+	if strings.HasSuffix(trimmed, unit) {
+		trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, unit))
+		multiplier = 1024
+	}
+	parsed, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil || parsed <= 0 {
+		return 0, false
+	}
+
+	return int(parsed * multiplier), true
+}
+
+// Unpack a list of ECS resources to retrieve the ports still available on a Container Instance
+func (state *State) getResourceAsPortSet(resources []*ecs.Resource, name string, defaultValue string) string {
+	for _, resource := range resources {
+		if resource != nil && aws.StringValue(resource.Name) == name && aws.StringValue(resource.Type) == "STRINGSET" {
+			return state.portStringBuilder(resource.StringSetValue)
+		}
+	}
+
+	return defaultValue
+}
+
+// A searchable string representation of ports in use to allow for queries of local state with port constraints.
+func (state *State) portStringBuilder(ports []*string) string {
+	var buffer bytes.Buffer
+	for _, port := range ports {
+		if port != nil {
+			buffer.WriteString(fmt.Sprintf("=%s=", *port))
+		}
+	}
+
+	return buffer.String()
+}
+
+// Reverses the searchable port format stored in the ContainerInstance port columns, turning a string
+// like =80==443= back into a list of port numbers.  Any malformed entries are skipped.
+func ParsePorts(encoded string) []int {
+	ports := []int{}
+	for _, port := range strings.Split(encoded, "=") {
+		if len(port) == 0 {
+			continue
+		}
+		if value, err := strconv.Atoi(port); err == nil {
+			ports = append(ports, value)
+		}
+	}
+
+	return ports
+}
+
+// Serializes a list of port numbers in the same searchable format as portStringBuilder.
+func encodePorts(ports []int) string {
+	var buffer bytes.Buffer
+	for _, port := range ports {
+		buffer.WriteString(fmt.Sprintf("=%d=", port))
+	}
+
+	return buffer.String()
+}
+
+// Builds the ARN of the configured cluster from the region and account of a ContainerInstance ARN, for use when the
+// cluster itself could not be described.  Returns an empty string if the ARN is not in the expected format.
+func (state *State) clusterARNFromContainerInstanceARN(containerInstanceARN string) string {
+	if strings.HasPrefix(state.clusterName, "arn:aws:ecs:") {
+		return state.clusterName
+	}
+	parts := strings.SplitN(containerInstanceARN, ":", 6)
+	if len(parts) != 6 || !strings.HasPrefix(parts[5], "container-instance/") {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s:%s:%s:%s:cluster/%s", parts[0], parts[1], parts[2], parts[3], parts[4], state.clusterName)
+}
+
+// Creates a ContainerInstance model to be used in a gorm Assign() call
+func (state *State) containerInstanceAssignment(cluster Cluster, containerInstance *ecs.ContainerInstance) ContainerInstance {
+	assignment := ContainerInstance{ClusterARN: cluster.ARN}
+	if len(assignment.ClusterARN) == 0 && containerInstance.ContainerInstanceArn != nil {
+		assignment.ClusterARN = state.clusterARNFromContainerInstanceARN(*containerInstance.ContainerInstanceArn)
+	}
+	if containerInstance.AgentConnected != nil {
+		assignment.AgentConnected = *containerInstance.AgentConnected
+	}
+	if containerInstance.VersionInfo != nil {
+		vi := containerInstance.VersionInfo
+		if vi.AgentHash != nil {
+			assignment.AgentHash = *vi.AgentHash
+		}
+		if vi.AgentVersion != nil {
+			assignment.AgentVersion = *vi.AgentVersion
+		}
+		if vi.DockerVersion != nil {
+			assignment.DockerVersion = *vi.DockerVersion
+		}
+	}
+	if containerInstance.AgentUpdateStatus != nil {
+		assignment.AgentUpdateStatus = *containerInstance.AgentUpdateStatus
+	}
+	if containerInstance.CapacityProviderName != nil {
+		assignment.CapacityProviderName = *containerInstance.CapacityProviderName
+	}
+	if containerInstance.Ec2InstanceId != nil {
+		assignment.EC2InstanceId = *containerInstance.Ec2InstanceId
+	}
+	if containerInstance.HealthStatus != nil && containerInstance.HealthStatus.OverallStatus != nil {
+		assignment.HealthStatus = *containerInstance.HealthStatus.OverallStatus
+	}
+	for _, attribute := range containerInstance.Attributes {
+		if attribute.Name != nil && *attribute.Name == "ecs.instance-type" && attribute.Value != nil {
+			assignment.InstanceType = *attribute.Value
+		}
+	}
+	if containerInstance.RegisteredResources != nil {
+		assignment.RegisteredCPU = state.getResourceAsInt(containerInstance.RegisteredResources, "CPU", 0)
+		assignment.RegisteredMemory = state.getResourceAsInt(containerInstance.RegisteredResources, "MEMORY", 0)
+		assignment.RegisteredTCPPorts = state.getResourceAsPortSet(containerInstance.RegisteredResources, "PORTS", "")
+		assignment.RegisteredUDPPorts = state.getResourceAsPortSet(containerInstance.RegisteredResources, "PORTS_UDP", "")
+	}
+	// Left marked as missing for resolveMissingResources
+	assignment.RemainingCPU = state.getResourceAsInt(containerInstance.RemainingResources, "CPU", missingResource)
+	assignment.RemainingMemory = state.getResourceAsInt(containerInstance.RemainingResources, "MEMORY", missingResource)
+	if containerInstance.RemainingResources != nil {
+		assignment.RemainingTCPPorts = state.getResourceAsPortSet(containerInstance.RemainingResources, "PORTS", "")
+		assignment.RemainingUDPPorts = state.getResourceAsPortSet(containerInstance.RemainingResources, "PORTS_UDP", "")
+	}
+	if containerInstance.Status != nil {
+		assignment.Status = *containerInstance.Status
+	}
+	return assignment
+}
+
+// Load the cluster, by name or full ARN, its default capacity provider strategy, and all ContainerInstances and Tasks into memory as Go objects.
+// A zero value Cluster is returned if the cluster is not found locally, use FindClusterByNameE to tell the two apart.
+func (state *State) FindClusterByName(name string) Cluster {
+	state.log.Info("entering FindClusterByName()")
+	cluster, _ := state.FindClusterByNameE(name)
+	return cluster
+}
+
+// Load the cluster, by name or full ARN, its default capacity provider strategy, and all ContainerInstances and Tasks into memory as Go objects,
+// returning ErrClusterNotFound if no such cluster has been refreshed into local state.
+func (state *State) FindClusterByNameE(name string) (Cluster, error) {
+	state.log.Info("entering FindClusterByNameE()")
+	queryString := "name = ?"
+	if strings.HasPrefix(name, "arn:aws:ecs:") {
+		queryString = "a_r_n = ?"
+	}
+	cluster := Cluster{}
+	query := state.DB().Where(queryString, name).Preload("DefaultCapacityProviderStrategy").Preload("ContainerInstances").Preload("Tasks").Preload("ContainerInstances.Tasks").First(&cluster)
+	if query.RecordNotFound() {
+		return Cluster{}, ErrClusterNotFound
+	}
+	return cluster, query.Error
+}
+
+// Resolve and cache locally a Task Definition from either a short string like my_app:1, a family, or a full ARN.
+// Cached definitions older than the configured TaskDefinitionTTL are described again.
+func (state *State) FindTaskDefinition(td string) (TaskDefinition, error) {
+	state.log.Info("entering FindTaskDefinition()")
+	taskDefinition, found := state.cachedTaskDefinition(td)
+	if !found {
+		state.log.Debug(fmt.Sprintf("TaskDefinition %s not found or expired, calling ECS service.", td))
+		refreshed, err := state.RefreshTaskDefinition(td)
+		if err != nil {
+			return TaskDefinition{}, err
+		}
+		taskDefinition = refreshed
+	}
+
+	taskDefinition = state.placementRequirements(taskDefinition)
+	state.log.Debug(fmt.Sprintf("TaskDefinition is: %+v", taskDefinition))
+	return taskDefinition, nil
+}
+
+// Resolve the latest revision of a Task Definition family, such as my_app, caching which revision the family resolved
+// to for the configured FamilyAliasTTL so that repeated lookups by family do not describe it from ECS every time.
+// Returns an error if family names a specific revision or ARN rather than a family alone.
+func (state *State) FindTaskDefinitionFamily(family string) (TaskDefinition, error) {
+	state.log.Info("entering FindTaskDefinitionFamily()")
+	if !isFamilyName(family) {
+		return TaskDefinition{}, fmt.Errorf("ecs_state: %q is not a task definition family", family)
+	}
+	return state.FindTaskDefinition(family)
+}
+
+// Resolve and cache locally several Task Definitions at once, keyed by the short strings or ARNs provided.  If any
+// describe fails, the definitions that were resolved are returned along with the first error.
+func (state *State) FindTaskDefinitions(tds []string) (map[string]TaskDefinition, error) {
+	state.log.Info("entering FindTaskDefinitions()")
+	taskDefinitions, err := state.resolveTaskDefinitions(tds)
+	for td, taskDefinition := range taskDefinitions {
+		taskDefinitions[td] = state.placementRequirements(taskDefinition)
+	}
+	return taskDefinitions, err
+}
+
+// Resolves several Task Definitions as FindTaskDefinitions does, but with the Cpu and Memory of every container
+// whatever the ExcludeNonEssentialContainers option, as running Tasks reserve them all.
+func (state *State) resolveTaskDefinitions(tds []string) (map[string]TaskDefinition, error) {
+	taskDefinitions := map[string]TaskDefinition{}
+	misses := []string{}
+	seen := map[string]bool{}
+	for _, td := range tds {
+		if seen[td] {
+			continue
+		}
+		seen[td] = true
+		if taskDefinition, found := state.cachedTaskDefinition(td); found {
+			taskDefinitions[td] = taskDefinition
+		} else {
+			misses = append(misses, td)
+		}
+	}
+	state.log.Debug(fmt.Sprintf("Found %d cached TaskDefinitions, describing %d from ECS", len(taskDefinitions), len(misses)))
+
+	type describeResult struct {
+		td         string
+		definition *ecs.TaskDefinition
+		err        error
+	}
+	concurrency := state.options.TaskDefinitionConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultTaskDefinitionConcurrency
+	}
+	semaphore := make(chan struct{}, concurrency)
+	results := make(chan describeResult, len(misses))
+	for _, td := range misses {
+		go func(td string) {
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			definition, err := state.describeTaskDefinition(td)
+			results <- describeResult{td: td, definition: definition, err: err}
+		}(td)
+	}
+
+	// Describes run in parallel, but results are stored one at a time to avoid contending for the database.
+	var firstErr error
+	for range misses {
+		result := <-results
+		if result.err != nil {
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			continue
+		}
+		taskDefinitions[result.td] = state.storeTaskDefinition(result.td, result.definition)
+	}
+
+	return taskDefinitions, firstErr
+}
+
+// Describes a Task Definition from ECS, by short string or full ARN, and replaces any locally cached copy.
+func (state *State) RefreshTaskDefinition(td string) (TaskDefinition, error) {
+	state.log.Info("entering RefreshTaskDefinition()")
+	definition, err := state.describeTaskDefinition(td)
+	if err != nil {
+		return TaskDefinition{}, err
+	}
+
+	return state.storeTaskDefinition(td, definition), nil
+}
+
+// Looks up a Task Definition, by short string or full ARN, in the local cache.  Returns false if it is
+// missing or has outlived the configured TaskDefinitionTTL.
+func (state *State) cachedTaskDefinition(td string) (TaskDefinition, bool) {
+	queryString := "short_string = ?"
+	if strings.HasPrefix(td, "arn:aws:ecs:") {
+		queryString = "a_r_n = ?"
+	} else if isFamilyName(td) {
+		alias := TaskDefinitionAlias{}
+		if state.DB().Where("family = ?", td).First(&alias).RecordNotFound() || state.aliasExpired(alias) {
+			return TaskDefinition{}, false
+		}
+		queryString, td = "a_r_n = ?", alias.TaskDefinitionARN
+	}
+
+	state.log.Debug("Query prefix is:", queryString)
 	taskDefinition := TaskDefinition{}
-	if state.DB().Where(queryString, td).First(&taskDefinition).RecordNotFound() {
-		state.log.Debug(fmt.Sprintf("TaskDefinition %s not found, calling ECS service.", td))
-		params := &ecs.DescribeTaskDefinitionInput{
-			TaskDefinition: aws.String(td),
+	if state.DB().Where(queryString, td).First(&taskDefinition).RecordNotFound() || state.taskDefinitionExpired(taskDefinition) {
+		return taskDefinition, false
+	}
+	return taskDefinition, true
+}
+
+// Calls the ECS DescribeTaskDefinition API for a short string or full ARN.  Returns ErrTaskDefinitionNotFound if the
+// response has no Task Definition.
+func (state *State) describeTaskDefinition(td string) (*ecs.TaskDefinition, error) {
+	params := &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(td),
+	}
+	resp, err := state.ecs_client.DescribeTaskDefinition(params)
+	if err != nil {
+		return nil, state.handleAwsError(err)
+	}
+	// A definition without an ARN cannot be cached, so it is treated as missing
+	if resp.TaskDefinition == nil || resp.TaskDefinition.TaskDefinitionArn == nil {
+		return nil, ErrTaskDefinitionNotFound
+	}
+	return resp.TaskDefinition, nil
+}
+
+// Stores a described Task Definition and its containers in the local cache, replacing any previous copy.  When td
+// was a bare family name, the revision it resolved to is remembered for later family lookups.
+func (state *State) storeTaskDefinition(td string, definition *ecs.TaskDefinition) TaskDefinition {
+	assignment := state.taskDefinitionAssignment(definition)
+	assignment.RefreshTime = int(state.now().Unix())
+	if isFamilyName(td) {
+		alias := TaskDefinitionAlias{}
+		state.DB().Where("family = ?", td).Attrs(TaskDefinitionAlias{Family: td}).Assign(TaskDefinitionAlias{TaskDefinitionARN: assignment.ARN, RefreshTime: assignment.RefreshTime}).FirstOrCreate(&alias)
+	}
+	containerDefinitions := assignment.ContainerDefinitions
+	assignment.ContainerDefinitions = nil
+	taskDefinition := TaskDefinition{}
+	state.DB().Where("a_r_n = ?", assignment.ARN).Assign(assignment).FirstOrCreate(&taskDefinition)
+
+	// Containers have no identity of their own within ECS, so they are replaced wholesale.
+	state.DB().Where("task_definition_a_r_n = ?", taskDefinition.ARN).Delete(ContainerDefinition{})
+	for _, containerDefinition := range containerDefinitions {
+		state.DB().Create(&containerDefinition)
+	}
+	taskDefinition.ContainerDefinitions = containerDefinitions
+	state.log.Debug(fmt.Sprintf("Refreshed TaskDefinition: %+v", taskDefinition))
+	return taskDefinition
+}
+
+// Builds the DescribeContainerInstances request for a page of ContainerInstance ARNs, including the optional fields
+// configured by IncludeTags and ContainerInstanceInclude.
+func (state *State) describeContainerInstancesInput(arns []*string) *ecs.DescribeContainerInstancesInput {
+	params := &ecs.DescribeContainerInstancesInput{
+		ContainerInstances: arns,
+		Cluster:            aws.String(state.clusterName),
+	}
+	included := map[string]bool{}
+	include := state.options.ContainerInstanceInclude
+	if state.options.IncludeTags {
+		include = append([]string{ecs.ContainerInstanceFieldTags}, include...)
+	}
+	for _, field := range include {
+		if !included[field] {
+			included[field] = true
+			params.Include = append(params.Include, aws.String(field))
 		}
-		resp, err := state.ecs_client.DescribeTaskDefinition(params)
-		if err != nil {
-			state.handleAwsError(err)
+	}
+	return params
+}
+
+// Replaces the Attributes stored for a ContainerInstance with those just described.  Like containers, attributes
+// have no identity of their own within ECS, so they are replaced wholesale.
+func (state *State) storeAttributes(db *gorm.DB, instanceARN string, attributes []*ecs.Attribute) error {
+	if err := db.Where("container_instance_a_r_n = ?", instanceARN).Delete(Attribute{}).Error; err != nil {
+		return err
+	}
+	for _, attribute := range attributes {
+		if attribute == nil || attribute.Name == nil {
+			continue
+		}
+		if err := db.Create(&Attribute{ContainerInstanceARN: instanceARN, Name: *attribute.Name, Value: aws.StringValue(attribute.Value)}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replaces the Tags stored for a Task or ContainerInstance with those just described, when Options.IncludeTags is set.
+func (state *State) storeTags(db *gorm.DB, resourceARN string, tags []*ecs.Tag) error {
+	if !state.options.IncludeTags {
+		return nil
+	}
+	if err := db.Where("resource_a_r_n = ?", resourceARN).Delete(Tag{}).Error; err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if tag == nil || tag.Key == nil {
+			continue
+		}
+		if err := db.Create(&Tag{ResourceARN: resourceARN, Key: *tag.Key, Value: aws.StringValue(tag.Value)}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replaces the ContainerOverrides stored for a Task.  The db is provided so that the overrides can be written within a
+// transaction.
+func (state *State) storeContainerOverrides(db *gorm.DB, taskARN string, containerOverrides []ContainerOverride) error {
+	if err := db.Where("task_a_r_n = ?", taskARN).Delete(ContainerOverride{}).Error; err != nil {
+		return err
+	}
+	for _, containerOverride := range containerOverrides {
+		if err := db.Create(&containerOverride).Error; err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		taskDefinition = TaskDefinition{
-			ARN:         *resp.TaskDefinition.TaskDefinitionArn,
-			ShortString: fmt.Sprintf("%s:%s", *resp.TaskDefinition.Family, strconv.Itoa(int(*resp.TaskDefinition.Revision))),
-			Cpu:         0,
-			Memory:      0,
+// Replaces the InstancePorts stored for a ContainerInstance with those in its port columns, when Options.NormalizedPorts
+// is set.  The db is provided so that the ports can be written within a transaction.
+func (state *State) storeInstancePorts(db *gorm.DB, containerInstance ContainerInstance) error {
+	if !state.options.NormalizedPorts {
+		return nil
+	}
+	if err := db.Where("container_instance_a_r_n = ?", containerInstance.ARN).Delete(InstancePort{}).Error; err != nil {
+		return err
+	}
+	for protocol, columns := range map[string][2]string{
+		"tcp": {containerInstance.RegisteredTCPPorts, containerInstance.RemainingTCPPorts},
+		"udp": {containerInstance.RegisteredUDPPorts, containerInstance.RemainingUDPPorts},
+	} {
+		inUse := map[int]bool{}
+		for _, port := range ParsePorts(columns[1]) {
+			inUse[port] = true
+		}
+		for _, port := range ParsePorts(columns[0]) {
+			if inUse[port] {
+				continue
+			}
+			if err := db.Create(&InstancePort{ContainerInstanceARN: containerInstance.ARN, Protocol: protocol, Port: port}).Error; err != nil {
+				return err
+			}
 		}
+		for port := range inUse {
+			if err := db.Create(&InstancePort{ContainerInstanceARN: containerInstance.ARN, Protocol: protocol, Port: port, InUse: true}).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Adjusts the Cpu and Memory of a Task Definition to what placement should require, leaving out
+// non-essential containers when the ExcludeNonEssentialContainers option is set.
+func (state *State) placementRequirements(taskDefinition TaskDefinition) TaskDefinition {
+	if state.options.ExcludeNonEssentialContainers {
+		taskDefinition.Cpu = taskDefinition.EssentialCpu
+		taskDefinition.Memory = taskDefinition.EssentialMemory
+	}
+	return taskDefinition
+}
+
+// Returns every Task Definition currently held in the local cache.
+func (state *State) ListCachedTaskDefinitions() []TaskDefinition {
+	state.log.Info("entering ListCachedTaskDefinitions()")
+	taskDefinitions := []TaskDefinition{}
+	state.DB().Find(&taskDefinitions)
+	return taskDefinitions
+}
+
+// Removes a Task Definition, by short string or full ARN, from the local cache.  The next lookup will describe it again.
+// Evicting a bare family name only forgets which revision it resolved to.
+func (state *State) EvictTaskDefinition(td string) {
+	state.log.Info("entering EvictTaskDefinition()")
+	if isFamilyName(td) {
+		state.DB().Where("family = ?", td).Delete(TaskDefinitionAlias{})
+		return
+	}
+	queryString := "short_string = ?"
+	if strings.HasPrefix(td, "arn:aws:ecs:") {
+		queryString = "a_r_n = ?"
+	}
+	taskDefinition := TaskDefinition{}
+	if state.DB().Where(queryString, td).First(&taskDefinition).RecordNotFound() {
+		return
+	}
+	state.DB().Where("task_definition_a_r_n = ?", taskDefinition.ARN).Delete(ContainerDefinition{})
+	state.DB().Delete(&taskDefinition)
+}
+
+// Whether a family alias has outlived the configured FamilyAliasTTL.
+func (state *State) aliasExpired(alias TaskDefinitionAlias) bool {
+	ttl := state.options.FamilyAliasTTL
+	if ttl <= 0 {
+		ttl = defaultFamilyAliasTTL
+	}
+	refreshed := time.Unix(int64(alias.RefreshTime), 0)
+	return state.now().Sub(refreshed) > ttl
+}
+
+// Whether td names only a Task Definition family, which ECS resolves to its latest revision, rather than a
+// family:revision short string or full ARN.
+func isFamilyName(td string) bool {
+	return !strings.HasPrefix(td, "arn:aws:ecs:") && !strings.Contains(td, ":")
+}
+
+// Whether a cached Task Definition has outlived the configured TaskDefinitionTTL.  A zero TTL caches forever.
+func (state *State) taskDefinitionExpired(taskDefinition TaskDefinition) bool {
+	if state.options.TaskDefinitionTTL <= 0 {
+		return false
+	}
+	refreshed := time.Unix(int64(taskDefinition.RefreshTime), 0)
+	return state.now().Sub(refreshed) > state.options.TaskDefinitionTTL
+}
+
+// Creates a TaskDefinition model to be used in a gorm Assign() call
+func (state *State) taskDefinitionAssignment(definition *ecs.TaskDefinition) TaskDefinition {
+	assignment := TaskDefinition{
+		ARN:         aws.StringValue(definition.TaskDefinitionArn),
+		ShortString: fmt.Sprintf("%s:%d", aws.StringValue(definition.Family), aws.Int64Value(definition.Revision)),
+		Cpu:         0,
+		Memory:      0,
+	}
 
-		tcpPorts := []string{}
-		udpPorts := []string{}
-		for _, containerDefinition := range resp.TaskDefinition.ContainerDefinitions {
-			taskDefinition.Cpu += int(*containerDefinition.Cpu)
-			taskDefinition.Memory += int(*containerDefinition.Memory)
-			for _, portMapping := range containerDefinition.PortMappings {
-				if portMapping.HostPort != nil && *portMapping.HostPort != 0 {
-					if portMapping.Protocol != nil && *portMapping.Protocol == ecs.TransportProtocolUdp {
-						udpPorts = append(udpPorts, strconv.Itoa(int(*portMapping.HostPort)))
+	tcpPorts := []string{}
+	udpPorts := []string{}
+	seenPorts := map[string]bool{}
+	networkMode := aws.StringValue(definition.NetworkMode)
+	bridged := networkMode == "" || networkMode == ecs.NetworkModeBridge
+	for _, containerDefinition := range definition.ContainerDefinitions {
+		if containerDefinition == nil {
+			continue
+		}
+		container := ContainerDefinition{TaskDefinitionARN: assignment.ARN, Essential: true}
+		if containerDefinition.Name != nil {
+			container.Name = *containerDefinition.Name
+		}
+		if containerDefinition.Essential != nil {
+			container.Essential = *containerDefinition.Essential
+		}
+		if containerDefinition.Cpu != nil {
+			container.Cpu = int(*containerDefinition.Cpu)
+		}
+		// Placement uses the hard memory limit when set, otherwise the soft limit reserved for the container.
+		if containerDefinition.MemoryReservation != nil {
+			assignment.MemoryReservation += int(*containerDefinition.MemoryReservation)
+		}
+		if containerDefinition.Memory != nil {
+			container.Memory = int(*containerDefinition.Memory)
+		} else if containerDefinition.MemoryReservation != nil {
+			container.Memory = int(*containerDefinition.MemoryReservation)
+		}
+		assignment.Cpu += container.Cpu
+		assignment.Memory += container.Memory
+		if container.Essential {
+			assignment.EssentialCpu += container.Cpu
+			assignment.EssentialMemory += container.Memory
+		}
+		containerTCPPorts := []string{}
+		containerUDPPorts := []string{}
+		for _, portMapping := range containerDefinition.PortMappings {
+			if portMapping == nil {
+				continue
+			}
+			protocol := ecs.TransportProtocolTcp
+			if portMapping.Protocol != nil && *portMapping.Protocol == ecs.TransportProtocolUdp {
+				protocol = ecs.TransportProtocolUdp
+			}
+			if portMapping.HostPort == nil || *portMapping.HostPort == 0 {
+				// Bridge networking binds a host port from the instance's ephemeral range instead
+				if bridged {
+					if protocol == ecs.TransportProtocolUdp {
+						assignment.DynamicUDPPorts++
 					} else {
-						tcpPorts = append(tcpPorts, strconv.Itoa(int(*portMapping.HostPort)))
+						assignment.DynamicTCPPorts++
 					}
 				}
+				continue
+			}
+			port := strconv.Itoa(int(*portMapping.HostPort))
+			// ECS rejects definitions binding the same host port twice, only the first binding is kept
+			// so that the placement query stays meaningful.
+			if seenPorts[protocol+port] {
+				state.log.Warn("TaskDefinition", assignment.ARN, "binds", protocol, "host port", port, "more than once, ignoring duplicate")
+				continue
+			}
+			seenPorts[protocol+port] = true
+			if protocol == ecs.TransportProtocolUdp {
+				udpPorts = append(udpPorts, port)
+				containerUDPPorts = append(containerUDPPorts, port)
+			} else {
+				tcpPorts = append(tcpPorts, port)
+				containerTCPPorts = append(containerTCPPorts, port)
 			}
 		}
-		taskDefinition.TCPPorts = strings.Join(tcpPorts, ",")
-		taskDefinition.UDPPorts = strings.Join(udpPorts, ",")
+		container.TCPPorts = strings.Join(containerTCPPorts, ",")
+		container.UDPPorts = strings.Join(containerUDPPorts, ",")
+		assignment.ContainerDefinitions = append(assignment.ContainerDefinitions, container)
+	}
+	// Task level resources, required by Fargate, take precedence over the sum of the containers.
+	// They are reserved for the task as a whole, so essential containers cannot be separated out.
+	if cpu, ok := state.getTaskResourceAsInt(definition.Cpu, "vcpu"); ok {
+		assignment.Cpu = cpu
+		assignment.EssentialCpu = cpu
+		assignment.TaskCpu = cpu
+	}
+	if memory, ok := state.getTaskResourceAsInt(definition.Memory, "gb"); ok {
+		assignment.Memory = memory
+		assignment.EssentialMemory = memory
+		assignment.TaskMemory = memory
+	}
+	assignment.TCPPorts = strings.Join(tcpPorts, ",")
+	assignment.UDPPorts = strings.Join(udpPorts, ",")
+	return assignment
+}
 
-		state.DB().Create(&taskDefinition)
-		state.log.Debug(fmt.Sprintf("Inserted TaskDefinition: %+v", taskDefinition))
+// Create a query requiring a remaining resource column to cover the required amount once scaled by factor and
+// increased by headroom.  The plain comparison is kept when neither applies so the resource index can be used.
+func (state *State) buildResourceQuery(column string, factor float64, headroom int, required int) (string, []interface{}) {
+	if (factor == 0 || factor == 1) && headroom == 0 {
+		return fmt.Sprintf("%s >= ?", column), []interface{}{required}
+	}
+	if factor == 0 {
+		factor = 1
 	}
+	return fmt.Sprintf("%s * ? + ? >= ?", column), []interface{}{factor, headroom, required}
+}
 
-	state.log.Debug(fmt.Sprintf("TaskDefinition is: %+v", taskDefinition))
-	return taskDefinition
+// Create a query requiring a free dynamic port column to cover the dynamic ports of a TaskDefinition along with any of
+// its fixed ports within the dynamic port range, or an empty query if it needs neither.
+func (state *State) buildDynamicPortQuery(column string, fixedPorts string, dynamic int) (string, []interface{}) {
+	needed := dynamic + state.fixedDynamicPorts(fixedPorts)
+	if needed == 0 {
+		return "", nil
+	}
+	return column + " >= ?", []interface{}{needed}
 }
 
-// Create a query for port constraints
-func (state *State) buildPortQuery(column, ports string) string {
+// Create a query for port constraints, returning the conditions along with the values to bind to their placeholders.
+func (state *State) buildPortQuery(column, ports string) (string, []interface{}) {
 	query := []string{}
+	args := []interface{}{}
 	for _, port := range strings.Split(ports, ",") {
 		if len(port) == 0 {
 			continue
 		}
 		// instr(a, b) will return zero if column a does not container string b.
 		// This format of query matches our serialization and allows for efficient port constraint.
-		query = append(query, fmt.Sprintf("instr(%s,\"=%s=\") = 0", column, port))
+		// The surrounding = delimiters keep port 53 from matching a reserved port 530.
+		query = append(query, fmt.Sprintf("instr(%s, ?) = 0", column))
+		args = append(args, fmt.Sprintf("=%s=", port))
+	}
+	return strings.Join(query, " AND "), args
+}
+
+// Create a port constraint against the InstancePort table, used in place of buildPortQuery when Options.NormalizedPorts
+// is set.  The column names the string column that would otherwise be checked, and so the protocol.
+func (state *State) buildNormalizedPortQuery(column, ports string) (string, []interface{}) {
+	protocol := "tcp"
+	if column == "remaining_udp_ports" {
+		protocol = "udp"
+	}
+	args := []interface{}{protocol}
+	for _, port := range strings.Split(ports, ",") {
+		if value, err := strconv.Atoi(port); err == nil {
+			args = append(args, value)
+		}
 	}
-	return strings.Join(query, " AND ")
+	if len(args) == 1 {
+		return "", nil
+	}
+
+	instancePorts := state.tableName(InstancePort{})
+	query := fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s WHERE %s.container_instance_a_r_n = %s.a_r_n AND %s.protocol = ? AND %s.in_use AND %s.port IN (%s))",
+		instancePorts, instancePorts, state.tableName(ContainerInstance{}), instancePorts, instancePorts, instancePorts,
+		strings.TrimSuffix(strings.Repeat("?,", len(args)-1), ","))
+	return query, args
 }
 
 // Returns all ContainerInstances where the desired TaskDefinition has resources available.
-// Additional filtering or constraints can be added if required.
+// Additional filtering or constraints can be added with FindLocationsForTaskDefinitionWithFilter.
 func (state *State) FindLocationsForTaskDefinition(td string) *[]ContainerInstance {
 	state.log.Info("entering FindLocationsForTaskDefinition()")
-	taskDefinition := state.FindTaskDefinition(td)
+	containerInstances := []ContainerInstance{}
+	taskDefinition, err := state.FindTaskDefinition(td)
+	if err != nil {
+		state.log.Warn("Unable to find TaskDefinition", td, err)
+		return &containerInstances
+	}
+	state.preparePlacement(context.Background())
+
+	state.placementQuery(state.DB(), taskDefinition, PlacementOptions{}).Find(&containerInstances)
+	return &containerInstances
+}
+
+// Returns all ContainerInstances where the desired TaskDefinition has resources available, as FindLocationsForTaskDefinition.
+// When Options.AutoRefreshAge is set, any refresh needed first is made with ctx, and its failure is returned along with
+// the locations found in local state as it was.
+func (state *State) FindLocationsForTaskDefinitionWithContext(ctx context.Context, td string) (*[]ContainerInstance, error) {
+	state.log.Info("entering FindLocationsForTaskDefinitionWithContext()")
+	containerInstances := []ContainerInstance{}
+	taskDefinition, err := state.FindTaskDefinition(td)
+	if err != nil {
+		return &containerInstances, err
+	}
+	err = state.preparePlacement(ctx)
+
+	state.placementQuery(state.DB(), taskDefinition, PlacementOptions{}).Find(&containerInstances)
+	return &containerInstances, err
+}
+
+// Returns all ContainerInstances where the desired TaskDefinition has resources available, as FindLocationsForTaskDefinition,
+// with the resource comparison adjusted by the provided PlacementOptions, for example to overcommit CPU.
+func (state *State) FindLocationsForTaskDefinitionWithOptions(td string, options PlacementOptions) *[]ContainerInstance {
+	state.log.Info("entering FindLocationsForTaskDefinitionWithOptions()")
+	containerInstances := []ContainerInstance{}
+	taskDefinition, err := state.FindTaskDefinition(td)
+	if err != nil {
+		state.log.Warn("Unable to find TaskDefinition", td, err)
+		return &containerInstances
+	}
+	state.preparePlacement(context.Background())
+
+	state.placementQuery(state.DB(), taskDefinition, options).Find(&containerInstances)
+	return &containerInstances
+}
+
+// Returns all ContainerInstances where the desired TaskDefinition has resources available and which also satisfy
+// the provided filter.  The filter receives the resource and port constrained query and may compose any further
+// gorm conditions onto it, for example restricting DockerVersion.
+func (state *State) FindLocationsForTaskDefinitionWithFilter(td string, filter func(*gorm.DB) *gorm.DB) *[]ContainerInstance {
+	state.log.Info("entering FindLocationsForTaskDefinitionWithFilter()")
+	containerInstances := []ContainerInstance{}
+	taskDefinition, err := state.FindTaskDefinition(td)
+	if err != nil {
+		state.log.Warn("Unable to find TaskDefinition", td, err)
+		return &containerInstances
+	}
+	state.preparePlacement(context.Background())
+
+	query := state.placementQuery(state.DB(), taskDefinition, PlacementOptions{})
+	if filter != nil {
+		query = filter(query)
+	}
+	query.Find(&containerInstances)
+	return &containerInstances
+}
+
+// Returns the ContainerInstance ranked first by options.Order, or LeastFreeCPU if Unordered, among those where the
+// TaskDefinition fits.  Returns ErrInsufficientCapacity if no instance fits.
+func (state *State) BestInstanceForTaskDefinition(td string, options PlacementOptions) (ContainerInstance, error) {
+	state.log.Info("entering BestInstanceForTaskDefinition()")
+	taskDefinition, err := state.FindTaskDefinition(td)
+	if err != nil {
+		return ContainerInstance{}, err
+	}
+	state.preparePlacement(context.Background())
+
+	if options.Order == Unordered {
+		options.Order = LeastFreeCPU
+	}
+	containerInstance := ContainerInstance{}
+	query := state.placementQuery(state.DB(), taskDefinition, options).First(&containerInstance)
+	if query.RecordNotFound() {
+		return ContainerInstance{}, ErrInsufficientCapacity
+	}
+	return containerInstance, query.Error
+}
+
+// Returns the connected, non-draining ContainerInstances with at least the given CPU units and memory remaining,
+// without involving a TaskDefinition, for example for ad-hoc capacity checks.
+func (state *State) FindInstancesWithCapacity(cpu, memory int) ([]ContainerInstance, error) {
+	state.log.Info("entering FindInstancesWithCapacity()")
+	state.preparePlacement(context.Background())
+	containerInstances := []ContainerInstance{}
+	err := state.DB().Where("remaining_cpu >= ? AND remaining_memory >= ? AND agent_connected = ? AND status <> ?",
+		cpu, memory, true, ecs.ContainerInstanceStatusDraining).Find(&containerInstances).Error
+	return containerInstances, err
+}
+
+// Returns the containers of a TaskDefinition with the CPU, memory and host ports each requires, resolving the
+// TaskDefinition as FindTaskDefinition does.
+func (state *State) FindContainerRequirements(td string) ([]ContainerDefinition, error) {
+	state.log.Info("entering FindContainerRequirements()")
+	containerDefinitions := []ContainerDefinition{}
+	taskDefinition, err := state.FindTaskDefinition(td)
+	if err != nil {
+		return containerDefinitions, err
+	}
+	err = state.DB().Where("task_definition_a_r_n = ?", taskDefinition.ARN).Order("id").Find(&containerDefinitions).Error
+	return containerDefinitions, err
+}
+
+// Describes each container of a TaskDefinition that no connected, non-draining ContainerInstance has the CPU or
+// memory free for, even on its own.
+func (state *State) ContainerShortfalls(td string) ([]string, error) {
+	state.log.Info("entering ContainerShortfalls()")
+	shortfalls := []string{}
+	containerDefinitions, err := state.FindContainerRequirements(td)
+	if err != nil {
+		return shortfalls, err
+	}
+
+	largestCPU, largestMemory, err := state.LargestPlaceable()
+	if err != nil {
+		return shortfalls, err
+	}
+
+	for _, container := range containerDefinitions {
+		if container.Cpu > largestCPU {
+			shortfalls = append(shortfalls, fmt.Sprintf("container %s needs %d CPU units and no instance has it free", container.Name, container.Cpu))
+		}
+		if container.Memory > largestMemory {
+			shortfalls = append(shortfalls, fmt.Sprintf("container %s needs %d MB of memory and no instance has it free", container.Name, container.Memory))
+		}
+	}
+	return shortfalls, nil
+}
+
+// Returns the largest remaining CPU and memory of any connected, non-draining ContainerInstance, maximized separately,
+// showing when capacity exists but is too fragmented to host a large task.
+func (state *State) LargestPlaceable() (maxCPU, maxMemory int, err error) {
+	state.log.Info("entering LargestPlaceable()")
+	state.preparePlacement(context.Background())
+	row := state.DB().Model(&ContainerInstance{}).Where("agent_connected = ? AND status <> ?", true, ecs.ContainerInstanceStatusDraining).
+		Select("COALESCE(MAX(remaining_cpu), 0), COALESCE(MAX(remaining_memory), 0)").Row()
+	err = row.Scan(&maxCPU, &maxMemory)
+	return maxCPU, maxMemory, err
+}
+
+// Returns all ContainerInstances with the resources to run a task of every listed TaskDefinition at once, for tasks that
+// must be co-located such as a cache and its consumer.  A TaskDefinition listed twice requires room for two tasks.
+// If the definitions use the same host port they can never share an instance, and no instances are returned.
+func (state *State) FindLocationsForTaskDefinitions(tds []string) ([]ContainerInstance, error) {
+	state.log.Info("entering FindLocationsForTaskDefinitions()")
+	containerInstances := []ContainerInstance{}
+	taskDefinitions, err := state.FindTaskDefinitions(tds)
+	if err != nil {
+		return containerInstances, err
+	}
+	state.preparePlacement(context.Background())
+
+	combined := TaskDefinition{}
+	tcpPorts := []string{}
+	udpPorts := []string{}
+	used := map[string]bool{}
+	for _, td := range tds {
+		taskDefinition := taskDefinitions[td]
+		combined.Cpu += taskDefinition.Cpu
+		combined.Memory += taskDefinition.Memory
+		combined.DynamicTCPPorts += taskDefinition.DynamicTCPPorts
+		combined.DynamicUDPPorts += taskDefinition.DynamicUDPPorts
+		for protocol, ports := range map[string]string{"tcp": taskDefinition.TCPPorts, "udp": taskDefinition.UDPPorts} {
+			for _, port := range strings.Split(ports, ",") {
+				if len(port) == 0 {
+					continue
+				}
+				if used[protocol+port] {
+					state.log.Debug(fmt.Sprintf("TaskDefinitions %v both use %s port %s and cannot be co-located", tds, protocol, port))
+					return containerInstances, nil
+				}
+				used[protocol+port] = true
+				if protocol == "tcp" {
+					tcpPorts = append(tcpPorts, port)
+				} else {
+					udpPorts = append(udpPorts, port)
+				}
+			}
+		}
+	}
+	combined.TCPPorts = strings.Join(tcpPorts, ",")
+	combined.UDPPorts = strings.Join(udpPorts, ",")
+
+	err = state.placementQuery(state.DB(), combined, PlacementOptions{}).Find(&containerInstances).Error
+	return containerInstances, err
+}
+
+// Returns all ContainerInstances where the desired TaskDefinition has resources available and no task of it is already
+// running, for spreading a singleton across hosts as the distinctInstance constraint of ECS does.
+func (state *State) FindLocationsWithoutTaskDefinition(td string) ([]ContainerInstance, error) {
+	state.log.Info("entering FindLocationsWithoutTaskDefinition()")
+	containerInstances := []ContainerInstance{}
+	taskDefinition, err := state.FindTaskDefinition(td)
+	if err != nil {
+		return containerInstances, err
+	}
+	state.preparePlacement(context.Background())
+
+	tasks := state.tableName(Task{})
+	err = state.placementQuery(state.DB(), taskDefinition, PlacementOptions{}).
+		Where(fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s WHERE %s.container_instance_a_r_n = %s.a_r_n AND %s.task_definition_a_r_n = ? AND %s.last_status <> ?)",
+			tasks, tasks, state.tableName(ContainerInstance{}), tasks, tasks), taskDefinition.ARN, ecs.DesiredStatusStopped).
+		Find(&containerInstances).Error
+	return containerInstances, err
+}
 
-	query := []string{"remaining_cpu >= ? AND remaining_memory >= ? AND agent_connected = ?"}
-	tcp_query := state.buildPortQuery("remaining_tcp_ports", taskDefinition.TCPPorts)
+// Builds the query on db for ContainerInstances with enough remaining resources and free ports for a TaskDefinition.
+func (state *State) placementQuery(db *gorm.DB, taskDefinition TaskDefinition, options PlacementOptions) *gorm.DB {
+	cpu_query, cpu_args := state.buildResourceQuery("remaining_cpu", options.CPUFactor, options.CPUHeadroom, taskDefinition.Cpu)
+	memory_query, memory_args := state.buildResourceQuery("remaining_memory", options.MemoryFactor, options.MemoryHeadroom, taskDefinition.Memory)
+	query := []string{cpu_query, memory_query, "agent_connected = ?"}
+	args := append(append(cpu_args, memory_args...), true)
+	if !options.IncludeDraining {
+		query = append(query, "status <> ?")
+		args = append(args, ecs.ContainerInstanceStatusDraining)
+	}
+	if len(options.CapacityProvider) > 0 {
+		query = append(query, "capacity_provider_name = ?")
+		args = append(args, options.CapacityProvider)
+	}
+	buildPortQuery := state.buildPortQuery
+	if state.options.NormalizedPorts {
+		buildPortQuery = state.buildNormalizedPortQuery
+	}
+	tcp_query, tcp_args := buildPortQuery("remaining_tcp_ports", taskDefinition.TCPPorts)
 	if len(tcp_query) > 0 {
 		query = append(query, tcp_query)
+		args = append(args, tcp_args...)
 	}
-	udp_query := state.buildPortQuery("remaining_udp_ports", taskDefinition.UDPPorts)
+	udp_query, udp_args := buildPortQuery("remaining_udp_ports", taskDefinition.UDPPorts)
 	if len(udp_query) > 0 {
 		query = append(query, udp_query)
+		args = append(args, udp_args...)
+	}
+	dynamic_tcp_query, dynamic_tcp_args := state.buildDynamicPortQuery("free_dynamic_tcp_ports", taskDefinition.TCPPorts, taskDefinition.DynamicTCPPorts)
+	if len(dynamic_tcp_query) > 0 {
+		query = append(query, dynamic_tcp_query)
+		args = append(args, dynamic_tcp_args...)
+	}
+	dynamic_udp_query, dynamic_udp_args := state.buildDynamicPortQuery("free_dynamic_udp_ports", taskDefinition.UDPPorts, taskDefinition.DynamicUDPPorts)
+	if len(dynamic_udp_query) > 0 {
+		query = append(query, dynamic_udp_query)
+		args = append(args, dynamic_udp_args...)
 	}
 	fullQuery := strings.Join(query, " AND ")
-	state.log.Debug("Full query is:", fullQuery)
+	state.log.Debug("Full query is:", fullQuery, args)
 
-	containerInstances := []ContainerInstance{}
-	state.DB().Where(fullQuery, taskDefinition.Cpu, taskDefinition.Memory, true).Find(&containerInstances)
-	return &containerInstances
+	placement := db.Where(fullQuery, args...)
+	if order := options.Order.clause(); len(order) > 0 {
+		placement = placement.Order(order)
+	}
+	if options.Limit > 0 {
+		placement = placement.Limit(options.Limit)
+	}
+	return placement
 }
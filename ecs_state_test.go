@@ -0,0 +1,177 @@
+package ecs_state_test
+
+import (
+	"io"
+	"log"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+// The cluster tracked by every test State.
+const (
+	testClusterName = "test"
+	testClusterARN  = "arn:aws:ecs:us-east-1:123456789012:cluster/test"
+)
+
+// Creates a State tracking the test cluster through client, logging nowhere, and closes it once the test finishes.
+func newTestState(t *testing.T, client *mocks.ECSAPI, options ecs_state.Options) *ecs_state.State {
+	state := ecs_state.InitializeWithOptions(testClusterName, client, ecs_state.Logger{Logger: log.New(io.Discard, "", 0)}, options)
+	t.Cleanup(func() { state.Close() })
+	return state
+}
+
+// Has client list and describe services as a single page.
+func expectServices(client *mocks.ECSAPI, services ...*ecs.Service) {
+	page := &ecs.ListServicesOutput{}
+	for _, service := range services {
+		page.ServiceArns = append(page.ServiceArns, service.ServiceArn)
+	}
+	client.On("ListServicesPages", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(1).(func(*ecs.ListServicesOutput, bool) bool)(page, true)
+	}).Return(nil)
+	client.On("DescribeServices", mock.Anything).Return(&ecs.DescribeServicesOutput{Services: services}, nil)
+}
+
+// Has client describe the test cluster.
+func expectCluster(client *mocks.ECSAPI) {
+	client.On("DescribeClusters", mock.Anything).Return(&ecs.DescribeClustersOutput{Clusters: []*ecs.Cluster{{
+		ClusterArn:  aws.String(testClusterARN),
+		ClusterName: aws.String(testClusterName),
+		Status:      aws.String("ACTIVE"),
+	}}}, nil).Maybe()
+}
+
+// Has client list and describe ContainerInstances as a single page, along with the test cluster they belong to.
+func expectContainerInstances(client *mocks.ECSAPI, containerInstances ...*ecs.ContainerInstance) {
+	expectCluster(client)
+	page := &ecs.ListContainerInstancesOutput{}
+	for _, containerInstance := range containerInstances {
+		page.ContainerInstanceArns = append(page.ContainerInstanceArns, containerInstance.ContainerInstanceArn)
+	}
+	client.On("ListContainerInstancesPages", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(1).(func(*ecs.ListContainerInstancesOutput, bool) bool)(page, true)
+	}).Return(nil)
+	client.On("DescribeContainerInstances", mock.Anything).Return(&ecs.DescribeContainerInstancesOutput{ContainerInstances: containerInstances}, nil)
+}
+
+// An ACTIVE, connected ContainerInstance with the given remaining CPU and memory, out of 4096 of each registered, and
+// with the given TCP ports in use.
+func containerInstance(id string, cpu int64, memory int64, tcpPorts ...string) *ecs.ContainerInstance {
+	return &ecs.ContainerInstance{
+		ContainerInstanceArn: aws.String("arn:aws:ecs:us-east-1:123456789012:container-instance/test/" + id),
+		Ec2InstanceId:        aws.String("i-" + id),
+		Status:               aws.String(ecs.ContainerInstanceStatusActive),
+		AgentConnected:       aws.Bool(true),
+		RegisteredResources: []*ecs.Resource{
+			{Name: aws.String("CPU"), Type: aws.String("INTEGER"), IntegerValue: aws.Int64(4096)},
+			{Name: aws.String("MEMORY"), Type: aws.String("INTEGER"), IntegerValue: aws.Int64(4096)},
+			{Name: aws.String("PORTS"), Type: aws.String("STRINGSET"), StringSetValue: aws.StringSlice([]string{"22"})},
+			{Name: aws.String("PORTS_UDP"), Type: aws.String("STRINGSET"), StringSetValue: []*string{}},
+		},
+		RemainingResources: []*ecs.Resource{
+			{Name: aws.String("CPU"), Type: aws.String("INTEGER"), IntegerValue: aws.Int64(cpu)},
+			{Name: aws.String("MEMORY"), Type: aws.String("INTEGER"), IntegerValue: aws.Int64(memory)},
+			{Name: aws.String("PORTS"), Type: aws.String("STRINGSET"), StringSetValue: aws.StringSlice(append([]string{"22"}, tcpPorts...))},
+			{Name: aws.String("PORTS_UDP"), Type: aws.String("STRINGSET"), StringSetValue: []*string{}},
+		},
+	}
+}
+
+// Sets the UDP ports in use on a ContainerInstance built by containerInstance.
+func withUDPPorts(containerInstance *ecs.ContainerInstance, udpPorts ...string) *ecs.ContainerInstance {
+	containerInstance.RemainingResources[3].StringSetValue = aws.StringSlice(udpPorts)
+	return containerInstance
+}
+
+// Has client describe each of definitions, by short string or ARN, and nothing else.
+func expectTaskDefinitions(client *mocks.ECSAPI, definitions ...*ecs.TaskDefinition) {
+	client.On("DescribeTaskDefinition", mock.Anything).Return(func(input *ecs.DescribeTaskDefinitionInput) *ecs.DescribeTaskDefinitionOutput {
+		for _, definition := range definitions {
+			shortString := aws.StringValue(definition.Family) + ":" + strconv.FormatInt(aws.Int64Value(definition.Revision), 10)
+			if name := aws.StringValue(input.TaskDefinition); name == shortString || name == aws.StringValue(definition.TaskDefinitionArn) {
+				return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: definition}
+			}
+		}
+		return &ecs.DescribeTaskDefinitionOutput{}
+	}, nil).Maybe()
+}
+
+// A bridged revision 1 of the family with a single essential container.
+func taskDefinition(family string, cpu int64, memory int64, portMappings ...*ecs.PortMapping) *ecs.TaskDefinition {
+	return &ecs.TaskDefinition{
+		TaskDefinitionArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/" + family + ":1"),
+		Family:            aws.String(family),
+		Revision:          aws.Int64(1),
+		ContainerDefinitions: []*ecs.ContainerDefinition{{
+			Name:         aws.String(family),
+			Cpu:          aws.Int64(cpu),
+			Memory:       aws.Int64(memory),
+			Essential:    aws.Bool(true),
+			PortMappings: portMappings,
+		}},
+	}
+}
+
+// A port mapping binding hostPort, or a dynamic host port if zero, with the given protocol.
+func portMapping(hostPort int64, protocol string) *ecs.PortMapping {
+	return &ecs.PortMapping{ContainerPort: aws.Int64(8080), HostPort: aws.Int64(hostPort), Protocol: aws.String(protocol)}
+}
+
+// The ARNs of containerInstances, for comparing placement results.
+func instanceARNs(containerInstances []ecs_state.ContainerInstance) []string {
+	arns := []string{}
+	for _, containerInstance := range containerInstances {
+		arns = append(arns, containerInstance.ARN)
+	}
+	return arns
+}
+
+// Has client list and describe Tasks as a single page, whatever desired status is listed.
+func expectTasks(client *mocks.ECSAPI, tasks ...*ecs.Task) {
+	page := &ecs.ListTasksOutput{}
+	for _, task := range tasks {
+		page.TaskArns = append(page.TaskArns, task.TaskArn)
+	}
+	client.On("ListTasksPages", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(1).(func(*ecs.ListTasksOutput, bool) bool)(page, true)
+	}).Return(nil)
+	client.On("DescribeTasks", mock.Anything).Return(&ecs.DescribeTasksOutput{Tasks: tasks}, nil)
+}
+
+// Has client list and describe Tasks as a single page once, when listing the given desired status, or when no status
+// is given if it is empty.
+func expectTasksWithStatus(client *mocks.ECSAPI, desiredStatus string, tasks ...*ecs.Task) {
+	page := &ecs.ListTasksOutput{}
+	for _, task := range tasks {
+		page.TaskArns = append(page.TaskArns, task.TaskArn)
+	}
+	client.On("ListTasksPages", mock.MatchedBy(func(params *ecs.ListTasksInput) bool {
+		return aws.StringValue(params.DesiredStatus) == desiredStatus
+	}), mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(1).(func(*ecs.ListTasksOutput, bool) bool)(page, true)
+	}).Return(nil).Once()
+	if len(tasks) == 0 {
+		return
+	}
+	client.On("DescribeTasks", mock.MatchedBy(func(params *ecs.DescribeTasksInput) bool {
+		return aws.StringValue(params.Tasks[0]) == aws.StringValue(tasks[0].TaskArn)
+	})).Return(&ecs.DescribeTasksOutput{Tasks: tasks}, nil).Once()
+}
+
+// A RUNNING Task of revision 1 of the family placed on the ContainerInstance built by containerInstance with the id.
+func task(id string, family string, instanceID string) *ecs.Task {
+	return &ecs.Task{
+		TaskArn:              aws.String("arn:aws:ecs:us-east-1:123456789012:task/test/" + id),
+		ClusterArn:           aws.String(testClusterARN),
+		ContainerInstanceArn: aws.String("arn:aws:ecs:us-east-1:123456789012:container-instance/test/" + instanceID),
+		TaskDefinitionArn:    aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/" + family + ":1"),
+		DesiredStatus:        aws.String(ecs.DesiredStatusRunning),
+		LastStatus:           aws.String(ecs.DesiredStatusRunning),
+	}
+}
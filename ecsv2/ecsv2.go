@@ -0,0 +1,246 @@
+// The ecsv2 package adapts the ECS client of aws-sdk-go-v2 to the ecs_state.ECSAPI interface, so that applications
+// which have moved to the v2 SDK can provide their existing client to ecs_state.Initialize.  The ECSAPI interface is
+// still expressed in v1 types, so requests and responses are converted between the two SDKs, which share the same
+// field names and JSON shapes.  Errors returned by ECS are converted to awserr.Error so that ecs_state recognizes
+// throttling and missing clusters as it does with the v1 client.
+//
+// Using this package therefore does not drop the v1 SDK: github.com/aws/aws-sdk-go remains a dependency alongside
+// aws-sdk-go-v2, though every request to ECS is made, and signed, by the v2 client.
+package ecsv2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	v1ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/smithy-go"
+	"github.com/jhspaybar/ecs_state"
+)
+
+// The subset of the v2 ECS client used by the Adapter.  It is satisfied by *ecs.Client.
+type Client interface {
+	DescribeClusters(context.Context, *ecs.DescribeClustersInput, ...func(*ecs.Options)) (*ecs.DescribeClustersOutput, error)
+	ListContainerInstances(context.Context, *ecs.ListContainerInstancesInput, ...func(*ecs.Options)) (*ecs.ListContainerInstancesOutput, error)
+	DescribeContainerInstances(context.Context, *ecs.DescribeContainerInstancesInput, ...func(*ecs.Options)) (*ecs.DescribeContainerInstancesOutput, error)
+	ListTasks(context.Context, *ecs.ListTasksInput, ...func(*ecs.Options)) (*ecs.ListTasksOutput, error)
+	DescribeTasks(context.Context, *ecs.DescribeTasksInput, ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error)
+	DescribeTaskDefinition(context.Context, *ecs.DescribeTaskDefinitionInput, ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error)
+	ListServices(context.Context, *ecs.ListServicesInput, ...func(*ecs.Options)) (*ecs.ListServicesOutput, error)
+	DescribeServices(context.Context, *ecs.DescribeServicesInput, ...func(*ecs.Options)) (*ecs.DescribeServicesOutput, error)
+}
+
+// Ensure the v2 SDK client can always be provided directly.
+var _ Client = (*ecs.Client)(nil)
+
+// Implements ecs_state.ECSAPI on top of a v2 ECS client.
+type Adapter struct {
+	client Client
+	ctx    context.Context
+}
+
+// Ensure the Adapter can always be provided to ecs_state.Initialize.
+var _ ecs_state.ECSAPI = (*Adapter)(nil)
+
+// Create an Adapter calling ECS through the provided v2 client, such as the result of ecs.NewFromConfig.
+func New(client Client) *Adapter {
+	return NewWithContext(context.Background(), client)
+}
+
+// Create an Adapter as with New, making every call with the provided context.
+func NewWithContext(ctx context.Context, client Client) *Adapter {
+	return &Adapter{client: client, ctx: ctx}
+}
+
+// Create a State for the named cluster as ecs_state.Initialize does, calling ECS through the provided v2 client.
+func Initialize(clusterName string, client Client, logger ecs_state.Logger) *ecs_state.State {
+	return ecs_state.Initialize(clusterName, New(client), logger)
+}
+
+// Calls DescribeClusters through the v2 client.
+func (adapter *Adapter) DescribeClusters(input *v1ecs.DescribeClustersInput) (*v1ecs.DescribeClustersOutput, error) {
+	params := &ecs.DescribeClustersInput{}
+	if err := convert(input, params); err != nil {
+		return nil, err
+	}
+	resp, err := adapter.client.DescribeClusters(adapter.ctx, params)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	output := &v1ecs.DescribeClustersOutput{}
+	return output, convert(resp, output)
+}
+
+// Calls ListContainerInstances through the v2 client, passing each page to fn until it returns false or the last page is reached.
+func (adapter *Adapter) ListContainerInstancesPages(input *v1ecs.ListContainerInstancesInput, fn func(*v1ecs.ListContainerInstancesOutput, bool) bool) error {
+	params := &ecs.ListContainerInstancesInput{}
+	if err := convert(input, params); err != nil {
+		return err
+	}
+	for {
+		resp, err := adapter.client.ListContainerInstances(adapter.ctx, params)
+		if err != nil {
+			return convertError(err)
+		}
+		page := &v1ecs.ListContainerInstancesOutput{}
+		if err := convert(resp, page); err != nil {
+			return err
+		}
+		lastPage := resp.NextToken == nil
+		if !fn(page, lastPage) || lastPage {
+			return nil
+		}
+		params.NextToken = resp.NextToken
+	}
+}
+
+// Calls DescribeContainerInstances through the v2 client.
+func (adapter *Adapter) DescribeContainerInstances(input *v1ecs.DescribeContainerInstancesInput) (*v1ecs.DescribeContainerInstancesOutput, error) {
+	params := &ecs.DescribeContainerInstancesInput{}
+	if err := convert(input, params); err != nil {
+		return nil, err
+	}
+	resp, err := adapter.client.DescribeContainerInstances(adapter.ctx, params)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	output := &v1ecs.DescribeContainerInstancesOutput{}
+	return output, convert(resp, output)
+}
+
+// Calls ListTasks through the v2 client, passing each page to fn until it returns false or the last page is reached.
+func (adapter *Adapter) ListTasksPages(input *v1ecs.ListTasksInput, fn func(*v1ecs.ListTasksOutput, bool) bool) error {
+	params := &ecs.ListTasksInput{}
+	if err := convert(input, params); err != nil {
+		return err
+	}
+	for {
+		resp, err := adapter.client.ListTasks(adapter.ctx, params)
+		if err != nil {
+			return convertError(err)
+		}
+		page := &v1ecs.ListTasksOutput{}
+		if err := convert(resp, page); err != nil {
+			return err
+		}
+		lastPage := resp.NextToken == nil
+		if !fn(page, lastPage) || lastPage {
+			return nil
+		}
+		params.NextToken = resp.NextToken
+	}
+}
+
+// Calls DescribeTasks through the v2 client.
+func (adapter *Adapter) DescribeTasks(input *v1ecs.DescribeTasksInput) (*v1ecs.DescribeTasksOutput, error) {
+	params := &ecs.DescribeTasksInput{}
+	if err := convert(input, params); err != nil {
+		return nil, err
+	}
+	resp, err := adapter.client.DescribeTasks(adapter.ctx, params)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	output := &v1ecs.DescribeTasksOutput{}
+	return output, convert(resp, output)
+}
+
+// Calls DescribeTaskDefinition through the v2 client.
+func (adapter *Adapter) DescribeTaskDefinition(input *v1ecs.DescribeTaskDefinitionInput) (*v1ecs.DescribeTaskDefinitionOutput, error) {
+	params := &ecs.DescribeTaskDefinitionInput{}
+	if err := convert(input, params); err != nil {
+		return nil, err
+	}
+	resp, err := adapter.client.DescribeTaskDefinition(adapter.ctx, params)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	output := &v1ecs.DescribeTaskDefinitionOutput{}
+	return output, convert(resp, output)
+}
+
+// Calls ListServices through the v2 client, passing each page to fn until it returns false or the last page is reached.
+func (adapter *Adapter) ListServicesPages(input *v1ecs.ListServicesInput, fn func(*v1ecs.ListServicesOutput, bool) bool) error {
+	params := &ecs.ListServicesInput{}
+	if err := convert(input, params); err != nil {
+		return err
+	}
+	for {
+		resp, err := adapter.client.ListServices(adapter.ctx, params)
+		if err != nil {
+			return convertError(err)
+		}
+		page := &v1ecs.ListServicesOutput{}
+		if err := convert(resp, page); err != nil {
+			return err
+		}
+		lastPage := resp.NextToken == nil
+		if !fn(page, lastPage) || lastPage {
+			return nil
+		}
+		params.NextToken = resp.NextToken
+	}
+}
+
+// Calls DescribeServices through the v2 client.
+func (adapter *Adapter) DescribeServices(input *v1ecs.DescribeServicesInput) (*v1ecs.DescribeServicesOutput, error) {
+	params := &ecs.DescribeServicesInput{}
+	if err := convert(input, params); err != nil {
+		return nil, err
+	}
+	resp, err := adapter.client.DescribeServices(adapter.ctx, params)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	output := &v1ecs.DescribeServicesOutput{}
+	return output, convert(resp, output)
+}
+
+// Copies a request or response between the SDKs.  Both name their fields after the ECS API, and differ only in
+// pointers, integer widths, and named string types for enums, none of which change the JSON representation.  The v2
+// SDK holds enums as plain strings, so an omitted one is encoded as an empty string, and is left out instead so that
+// it is nil in the v1 types as it would be from the v1 client.
+func convert(from interface{}, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	var fields interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if data, err = json.Marshal(dropEmptyStrings(fields)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
+
+// Removes the fields holding empty strings from decoded JSON objects, at any depth.
+func dropEmptyStrings(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if field == "" {
+				delete(value, key)
+				continue
+			}
+			value[key] = dropEmptyStrings(field)
+		}
+	case []interface{}:
+		for i, element := range value {
+			value[i] = dropEmptyStrings(element)
+		}
+	}
+	return value
+}
+
+// Converts an error from the v2 SDK to an awserr.Error carrying the same ECS error code.
+func convertError(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return awserr.New(apiErr.ErrorCode(), apiErr.ErrorMessage(), err)
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package ecsv2_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	v1ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/smithy-go"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/ecsv2"
+)
+
+// A v2 Client serving canned responses.  Task pages are keyed by the NextToken requesting them, the first by "".
+type fakeClient struct {
+	ecsv2.Client
+	taskPages      map[string]*ecs.ListTasksOutput
+	taskDefinition *types.TaskDefinition
+	err            error
+}
+
+func (client *fakeClient) ListTasks(ctx context.Context, input *ecs.ListTasksInput, optFns ...func(*ecs.Options)) (*ecs.ListTasksOutput, error) {
+	if client.err != nil {
+		return nil, client.err
+	}
+	return client.taskPages[aws.StringValue(input.NextToken)], nil
+}
+
+func (client *fakeClient) DescribeTaskDefinition(ctx context.Context, input *ecs.DescribeTaskDefinitionInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error) {
+	if client.err != nil {
+		return nil, client.err
+	}
+	return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: client.taskDefinition}, nil
+}
+
+func TestListTasksPages(t *testing.T) {
+	client := &fakeClient{taskPages: map[string]*ecs.ListTasksOutput{
+		"":       {TaskArns: []string{"a", "b"}, NextToken: aws.String("second")},
+		"second": {TaskArns: []string{"c"}, NextToken: aws.String("third")},
+		"third":  {TaskArns: []string{"d"}},
+	}}
+	adapter := ecsv2.New(client)
+
+	listed := []string{}
+	lastPages := []bool{}
+	err := adapter.ListTasksPages(&v1ecs.ListTasksInput{}, func(page *v1ecs.ListTasksOutput, lastPage bool) bool {
+		listed = append(listed, aws.StringValueSlice(page.TaskArns)...)
+		lastPages = append(lastPages, lastPage)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(listed, []string{"a", "b", "c", "d"}) || !reflect.DeepEqual(lastPages, []bool{false, false, true}) {
+		t.Errorf("listed %v with last pages %v, want every task over three pages", listed, lastPages)
+	}
+
+	pages := 0
+	err = adapter.ListTasksPages(&v1ecs.ListTasksInput{}, func(page *v1ecs.ListTasksOutput, lastPage bool) bool {
+		pages++
+		return false
+	})
+	if err != nil || pages != 1 {
+		t.Errorf("listed %d pages (%v) after asking to stop, want 1", pages, err)
+	}
+}
+
+func TestErrorsConvertToAWSErrors(t *testing.T) {
+	adapter := ecsv2.New(&fakeClient{err: &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}})
+
+	_, err := adapter.DescribeTaskDefinition(&v1ecs.DescribeTaskDefinitionInput{TaskDefinition: aws.String("web:1")})
+	awsErr, ok := err.(awserr.Error)
+	if !ok || awsErr.Code() != "ThrottlingException" || awsErr.Message() != "Rate exceeded" {
+		t.Errorf("DescribeTaskDefinition returned %#v, want an awserr.Error with the ECS error code", err)
+	}
+	err = adapter.ListTasksPages(&v1ecs.ListTasksInput{}, func(*v1ecs.ListTasksOutput, bool) bool { return true })
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "ThrottlingException" {
+		t.Errorf("ListTasksPages returned %#v, want an awserr.Error with the ECS error code", err)
+	}
+}
+
+func TestTaskDefinitionRoundTrip(t *testing.T) {
+	client := &fakeClient{taskDefinition: &types.TaskDefinition{
+		TaskDefinitionArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/web:3"),
+		Family:            aws.String("web"),
+		Revision:          3,
+		ContainerDefinitions: []types.ContainerDefinition{{
+			Name:   aws.String("web"),
+			Cpu:    256,
+			Memory: aws.Int32(512),
+			PortMappings: []types.PortMapping{
+				{ContainerPort: aws.Int32(8080), HostPort: aws.Int32(0)},
+				{ContainerPort: aws.Int32(53), HostPort: aws.Int32(53), Protocol: types.TransportProtocolUdp},
+			},
+		}},
+	}}
+	adapter := ecsv2.New(client)
+
+	resp, err := adapter.DescribeTaskDefinition(&v1ecs.DescribeTaskDefinitionInput{TaskDefinition: aws.String("web:3")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	definition := resp.TaskDefinition
+	if definition.NetworkMode != nil || definition.Status != nil {
+		t.Errorf("omitted enums converted to NetworkMode %q and Status %q, want nil", aws.StringValue(definition.NetworkMode), aws.StringValue(definition.Status))
+	}
+	if aws.Int64Value(definition.Revision) != 3 || aws.StringValue(definition.Family) != "web" {
+		t.Errorf("converted %s revision %d, want web revision 3", aws.StringValue(definition.Family), aws.Int64Value(definition.Revision))
+	}
+	mappings := definition.ContainerDefinitions[0].PortMappings
+	if mappings[0].Protocol != nil || aws.StringValue(mappings[1].Protocol) != v1ecs.TransportProtocolUdp || aws.Int64Value(mappings[1].HostPort) != 53 {
+		t.Errorf("converted port mappings %v, want the omitted protocol nil and UDP 53 kept", mappings)
+	}
+
+	// A bridged mapping without a host port still counts as a dynamic port once stored
+	state := ecs_state.Initialize("test", adapter, ecs_state.Logger{Logger: log.New(io.Discard, "", 0)})
+	defer state.Close()
+	taskDefinition, err := state.FindTaskDefinition("web:3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taskDefinition.DynamicTCPPorts != 1 || taskDefinition.UDPPorts != "53" || taskDefinition.Cpu != 256 || taskDefinition.Memory != 512 {
+		t.Errorf("stored %+v, want 256 CPU, 512 memory, UDP port 53 and one dynamic TCP port", taskDefinition)
+	}
+}
@@ -0,0 +1,97 @@
+package ecs_state
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Returned when a cluster is not present in the local state, either because it has not been refreshed or does not exist.
+var ErrClusterNotFound = errors.New("ecs_state: cluster not found")
+
+// Returned when a ContainerInstance is not present in the local state.
+var ErrContainerInstanceNotFound = errors.New("ecs_state: container instance not found")
+
+// Returned when a Task is not present in the local state.
+var ErrTaskNotFound = errors.New("ecs_state: task not found")
+
+// Returned when a Service is not present in the local state, either because services have not been refreshed or it does not exist.
+var ErrServiceNotFound = errors.New("ecs_state: service not found")
+
+// Matches, with errors.Is, any ECSError caused by ECS throttling requests.  Callers should back off and retry.
+var ErrThrottled = errors.New("ecs_state: request throttled by ECS")
+
+// Returned when ECS describes no Task Definition for the requested family, revision, or ARN.
+var ErrTaskDefinitionNotFound = errors.New("ecs_state: task definition not found")
+
+// Returned when a Task stops, or disappears from ECS, before reaching the status being waited for.
+var ErrTaskStopped = errors.New("ecs_state: task stopped")
+
+// Returned when the local state has no room left for the requested placements.
+var ErrInsufficientCapacity = errors.New("ecs_state: insufficient capacity for placement")
+
+// Returned for any statement other than a single SELECT made through ReadOnlyDB.
+var ErrReadOnlyDatabase = errors.New("ecs_state: database is read-only")
+
+// The errors from each refresh that failed during RefreshAll.
+type RefreshErrors []error
+
+// Combines the messages of every failed refresh.
+func (errs RefreshErrors) Error() string {
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Allows errors.Is and errors.As to match the error of any refresh that failed.
+func (errs RefreshErrors) Unwrap() []error {
+	return errs
+}
+
+// An error returned by a call to the ECS API.  It unwraps to the original error from the AWS SDK, normally an
+// awserr.Error, and matches ErrThrottled or ErrClusterNotFound with errors.Is when ECS reported either condition.
+type ECSError struct {
+	Err error
+}
+
+// The AWS error codes that indicate a request was throttled.
+var throttleCodes = map[string]bool{
+	"Throttling":                             true,
+	"ThrottlingException":                    true,
+	"ThrottledException":                     true,
+	"RequestThrottled":                       true,
+	"RequestThrottledException":              true,
+	"TooManyRequestsException":               true,
+	"RequestLimitExceeded":                   true,
+	"ProvisionedThroughputExceededException": true,
+}
+
+// Describes the underlying AWS error.
+func (err *ECSError) Error() string {
+	return "ecs_state: error calling ECS: " + err.Err.Error()
+}
+
+// Returns the original error from the AWS SDK.
+func (err *ECSError) Unwrap() error {
+	return err.Err
+}
+
+// Matches ErrThrottled and ErrClusterNotFound based on the AWS error code.
+func (err *ECSError) Is(target error) bool {
+	awsErr, ok := err.Err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	switch target {
+	case ErrThrottled:
+		return throttleCodes[awsErr.Code()]
+	case ErrClusterNotFound:
+		return awsErr.Code() == ecs.ErrCodeClusterNotFoundException
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package ecs_state_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestFindTaskDefinitionMissingFromResponse(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	client.On("DescribeTaskDefinition", mock.Anything).Return(&ecs.DescribeTaskDefinitionOutput{}, nil)
+	state := newTestState(t, client, ecs_state.Options{})
+
+	if _, err := state.FindTaskDefinition("web:1"); err != ecs_state.ErrTaskDefinitionNotFound {
+		t.Errorf("FindTaskDefinition returned %v, want ErrTaskDefinitionNotFound", err)
+	}
+	if _, err := state.RefreshTaskDefinition("web:1"); err != ecs_state.ErrTaskDefinitionNotFound {
+		t.Errorf("RefreshTaskDefinition returned %v, want ErrTaskDefinitionNotFound", err)
+	}
+}
+
+func TestFindTaskDefinitionDescribeError(t *testing.T) {
+	describeErr := errors.New("throttled")
+	client := mocks.NewECSAPI(t)
+	client.On("DescribeTaskDefinition", mock.Anything).Return(nil, describeErr)
+	state := newTestState(t, client, ecs_state.Options{})
+
+	_, err := state.FindTaskDefinition("web:1")
+	var ecsErr *ecs_state.ECSError
+	if !errors.As(err, &ecsErr) || !errors.Is(err, describeErr) {
+		t.Errorf("FindTaskDefinition returned %v, want the describe error wrapped in an ECSError", err)
+	}
+	if _, err := state.FindLocationsForTaskDefinitionWithContext(context.Background(), "web:1"); !errors.Is(err, describeErr) {
+		t.Errorf("FindLocationsForTaskDefinitionWithContext returned %v, want the describe error", err)
+	}
+	if locations := state.FindLocationsForTaskDefinition("web:1"); len(*locations) != 0 {
+		t.Errorf("found %d locations for an undescribable definition, want none", len(*locations))
+	}
+}
@@ -0,0 +1,88 @@
+package ecs_state
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// How many ContainerInstances each placement constraint rules out for a TaskDefinition, returned by ExplainPlacement.
+// Each constraint is counted independently, so an instance failing several is counted against each of them, and
+// the counts need not add up to Total minus Candidates.
+type PlacementExplanation struct {
+	// The TaskDefinition the placement was explained for.
+	TaskDefinitionARN string
+	// How many ContainerInstances are in local state.
+	Total int
+	// How many ContainerInstances satisfy every constraint, as FindLocationsForTaskDefinition would return.
+	Candidates int
+	// Instances without enough remaining CPU.
+	InsufficientCPU int
+	// Instances without enough remaining memory.
+	InsufficientMemory int
+	// Instances with a TCP host port the TaskDefinition needs already in use.
+	TCPPortConflicts int
+	// Instances with a UDP host port the TaskDefinition needs already in use.
+	UDPPortConflicts int
+	// Instances without enough TCP ports left in the dynamic port range.
+	InsufficientDynamicTCPPorts int
+	// Instances without enough UDP ports left in the dynamic port range.
+	InsufficientDynamicUDPPorts int
+	// Instances whose agent is disconnected.
+	AgentDisconnected int
+	// Instances that are DRAINING.
+	Draining int
+}
+
+// Explains which constraints rule out ContainerInstances for a TaskDefinition, for example to tell why
+// FindLocationsForTaskDefinition returns no locations: a lack of CPU, memory, or free ports, or disconnected agents.
+func (state *State) ExplainPlacement(td string) (PlacementExplanation, error) {
+	state.log.Info("entering ExplainPlacement()")
+	taskDefinition, err := state.FindTaskDefinition(td)
+	if err != nil {
+		return PlacementExplanation{}, err
+	}
+	state.preparePlacement(context.Background())
+
+	explanation := PlacementExplanation{TaskDefinitionARN: taskDefinition.ARN}
+	cpuQuery, cpuArgs := state.buildResourceQuery("remaining_cpu", 0, 0, taskDefinition.Cpu)
+	memoryQuery, memoryArgs := state.buildResourceQuery("remaining_memory", 0, 0, taskDefinition.Memory)
+	buildPortQuery := state.buildPortQuery
+	if state.options.NormalizedPorts {
+		buildPortQuery = state.buildNormalizedPortQuery
+	}
+	tcpQuery, tcpArgs := buildPortQuery("remaining_tcp_ports", taskDefinition.TCPPorts)
+	udpQuery, udpArgs := buildPortQuery("remaining_udp_ports", taskDefinition.UDPPorts)
+	dynamicTCPQuery, dynamicTCPArgs := state.buildDynamicPortQuery("free_dynamic_tcp_ports", taskDefinition.TCPPorts, taskDefinition.DynamicTCPPorts)
+	dynamicUDPQuery, dynamicUDPArgs := state.buildDynamicPortQuery("free_dynamic_udp_ports", taskDefinition.UDPPorts, taskDefinition.DynamicUDPPorts)
+
+	constraints := []struct {
+		count *int
+		query string
+		args  []interface{}
+	}{
+		{&explanation.InsufficientCPU, cpuQuery, cpuArgs},
+		{&explanation.InsufficientMemory, memoryQuery, memoryArgs},
+		{&explanation.TCPPortConflicts, tcpQuery, tcpArgs},
+		{&explanation.UDPPortConflicts, udpQuery, udpArgs},
+		{&explanation.InsufficientDynamicTCPPorts, dynamicTCPQuery, dynamicTCPArgs},
+		{&explanation.InsufficientDynamicUDPPorts, dynamicUDPQuery, dynamicUDPArgs},
+		{&explanation.AgentDisconnected, "agent_connected = ?", []interface{}{true}},
+		{&explanation.Draining, "status <> ?", []interface{}{ecs.ContainerInstanceStatusDraining}},
+	}
+	if err := state.DB().Model(&ContainerInstance{}).Count(&explanation.Total).Error; err != nil {
+		return explanation, err
+	}
+	for _, constraint := range constraints {
+		// Definitions without ports of a protocol, or dynamic ports, have no constraint to fail
+		if len(constraint.query) == 0 {
+			continue
+		}
+		err := state.DB().Model(&ContainerInstance{}).Where("NOT ("+constraint.query+")", constraint.args...).Count(constraint.count).Error
+		if err != nil {
+			return explanation, err
+		}
+	}
+	err = state.placementQuery(state.DB().Model(&ContainerInstance{}), taskDefinition, PlacementOptions{}).Count(&explanation.Candidates).Error
+	return explanation, err
+}
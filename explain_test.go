@@ -0,0 +1,61 @@
+package ecs_state_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+)
+
+func TestExplainPlacement(t *testing.T) {
+	draining := containerInstance("draining", 2048, 2048)
+	draining.Status = aws.String(ecs.ContainerInstanceStatusDraining)
+	disconnected := containerInstance("disconnected", 2048, 2048)
+	disconnected.AgentConnected = aws.Bool(false)
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client,
+		containerInstance("fits", 2048, 2048),
+		containerInstance("cpu", 128, 2048),
+		containerInstance("memory", 2048, 128),
+		containerInstance("tcp", 2048, 2048, "8080"),
+		withUDPPorts(containerInstance("udp", 2048, 2048), "53"),
+		draining,
+		disconnected,
+		// Short of both CPU and memory, so counted against each
+		containerInstance("both", 128, 128))
+	definition := taskDefinition("web", 256, 256, portMapping(8080, "tcp"))
+	definition.ContainerDefinitions[0].PortMappings = append(definition.ContainerDefinitions[0].PortMappings, portMapping(53, "udp"))
+	expectTaskDefinitions(client, definition)
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+
+	explanation, err := state.ExplainPlacement("web:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ecs_state.PlacementExplanation{
+		TaskDefinitionARN:  *definition.TaskDefinitionArn,
+		Total:              8,
+		Candidates:         1,
+		InsufficientCPU:    2,
+		InsufficientMemory: 2,
+		TCPPortConflicts:   1,
+		UDPPortConflicts:   1,
+		AgentDisconnected:  1,
+		Draining:           1,
+	}
+	if explanation != want {
+		t.Errorf("explained %+v, want %+v", explanation, want)
+	}
+	if locations := *state.FindLocationsForTaskDefinition("web:1"); len(locations) != explanation.Candidates {
+		t.Errorf("found %d locations, want the %d candidates explained", len(locations), explanation.Candidates)
+	}
+
+	if _, err := state.ExplainPlacement("missing:1"); err != ecs_state.ErrTaskDefinitionNotFound {
+		t.Errorf("explained a missing definition with %v, want ErrTaskDefinitionNotFound", err)
+	}
+}
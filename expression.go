@@ -0,0 +1,308 @@
+package ecs_state
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Compiles an expression in a subset of the ECS cluster query language, such as attribute:ecs.instance-type =~ m5.*,
+// into a filter for FindLocationsForTaskDefinitionWithFilter.  Subjects are attribute:<name>, agentConnected,
+// agentVersion, and ec2InstanceId, and patterns are case-sensitive ECS wildcards rather than regular expressions.
+func CompileExpression(expression string) (func(*gorm.DB) *gorm.DB, error) {
+	tokens, err := tokenizeExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+	parser := &expressionParser{tokens: tokens}
+	query, args, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !parser.done() {
+		return nil, fmt.Errorf("ecs_state: unexpected %q in expression %q", parser.peek(), expression)
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		prefix := tablePrefix(db)
+		tables := strings.NewReplacer(expressionInstances, prefix+ContainerInstance{}.TableName(), expressionAttributes, prefix+Attribute{}.TableName())
+		return db.Where(tables.Replace(query), args...)
+	}, nil
+}
+
+// Stand in for table names in a compiled expression until its filter is applied, since the Options.TablePrefix of
+// the State is only known from the database the filter is given.
+const (
+	expressionInstances  = "{container_instances}"
+	expressionAttributes = "{attributes}"
+)
+
+// Returns all ContainerInstances where the desired TaskDefinition has resources available, as FindLocationsForTaskDefinition,
+// which also match an expression in the cluster query language accepted by CompileExpression.
+func (state *State) FindLocationsForTaskDefinitionWithExpression(td string, expression string) (*[]ContainerInstance, error) {
+	state.log.Info("entering FindLocationsForTaskDefinitionWithExpression()")
+	filter, err := CompileExpression(expression)
+	if err != nil {
+		return &[]ContainerInstance{}, err
+	}
+	return state.FindLocationsForTaskDefinitionWithFilter(td, filter), nil
+}
+
+// The ContainerInstance columns that may be used as subjects of an expression, keyed by their query language name.
+var expressionColumns = map[string]string{
+	"agentConnected": "agent_connected",
+	"agentVersion":   "agent_version",
+	"ec2InstanceId":  "ec2_instance_id",
+}
+
+// The two character operators and boolean connectives.
+var expressionSymbols = map[string]bool{"==": true, "!=": true, "=~": true, "!~": true, "&&": true, "||": true}
+
+// Spelled out operators accepted in place of their symbols.
+var expressionOperators = map[string]string{
+	"equals":      "==",
+	"not_equals":  "!=",
+	"not_exists":  "!exists",
+	"matches":     "=~",
+	"not_matches": "!~",
+}
+
+// Splits an expression into words, operators, parentheses and commas.
+func tokenizeExpression(expression string) ([]string, error) {
+	tokens := []string{}
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',':
+			tokens = append(tokens, string(r))
+			i++
+		case strings.ContainsRune("=!&|", r):
+			if i+1 < len(runes) && expressionSymbols[string(runes[i:i+2])] {
+				tokens = append(tokens, string(runes[i:i+2]))
+				i += 2
+			} else if r == '!' {
+				tokens = append(tokens, "!")
+				i++
+			} else {
+				return nil, fmt.Errorf("ecs_state: unexpected %q in expression %q", r, expression)
+			}
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("(),=!&|", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		}
+	}
+	return tokens, nil
+}
+
+// A recursive descent parser producing a SQL condition and its arguments from expression tokens.
+type expressionParser struct {
+	tokens   []string
+	position int
+}
+
+// Whether every token has been consumed.
+func (parser *expressionParser) done() bool {
+	return parser.position >= len(parser.tokens)
+}
+
+// The next token without consuming it, or empty at the end of the expression.
+func (parser *expressionParser) peek() string {
+	if parser.done() {
+		return ""
+	}
+	return parser.tokens[parser.position]
+}
+
+// Consumes and returns the next token, or empty at the end of the expression.
+func (parser *expressionParser) next() string {
+	token := parser.peek()
+	parser.position++
+	return token
+}
+
+// Consumes the next token, which must be the expected one.
+func (parser *expressionParser) expect(expected string) error {
+	if token := parser.next(); token != expected {
+		return fmt.Errorf("ecs_state: expected %q in expression but found %q", expected, token)
+	}
+	return nil
+}
+
+// Parses conditions joined by or, which binds more loosely than and.
+func (parser *expressionParser) parseOr() (string, []interface{}, error) {
+	query, args, err := parser.parseAnd()
+	for err == nil && (strings.EqualFold(parser.peek(), "or") || parser.peek() == "||") {
+		parser.next()
+		var right string
+		var rightArgs []interface{}
+		right, rightArgs, err = parser.parseAnd()
+		query = "(" + query + " OR " + right + ")"
+		args = append(args, rightArgs...)
+	}
+	return query, args, err
+}
+
+// Parses conditions joined by and.
+func (parser *expressionParser) parseAnd() (string, []interface{}, error) {
+	query, args, err := parser.parseFactor()
+	for err == nil && (strings.EqualFold(parser.peek(), "and") || parser.peek() == "&&") {
+		parser.next()
+		var right string
+		var rightArgs []interface{}
+		right, rightArgs, err = parser.parseFactor()
+		query = "(" + query + " AND " + right + ")"
+		args = append(args, rightArgs...)
+	}
+	return query, args, err
+}
+
+// Parses a negated or parenthesized expression, or a single condition.
+func (parser *expressionParser) parseFactor() (string, []interface{}, error) {
+	switch token := parser.peek(); {
+	case strings.EqualFold(token, "not") || token == "!":
+		parser.next()
+		query, args, err := parser.parseFactor()
+		return "NOT (" + query + ")", args, err
+	case token == "(":
+		parser.next()
+		query, args, err := parser.parseOr()
+		if err != nil {
+			return "", nil, err
+		}
+		if err := parser.expect(")"); err != nil {
+			return "", nil, err
+		}
+		return "(" + query + ")", args, nil
+	}
+	return parser.parseCondition()
+}
+
+// Parses a subject, an operator, and any values the operator takes.
+func (parser *expressionParser) parseCondition() (string, []interface{}, error) {
+	subject := parser.next()
+	if len(subject) == 0 {
+		return "", nil, fmt.Errorf("ecs_state: expression ended where a condition was expected")
+	}
+
+	operator := parser.next()
+	if operator == "!" && parser.peek() == "exists" {
+		operator = "!" + parser.next()
+	}
+	if spelled, ok := expressionOperators[operator]; ok {
+		operator = spelled
+	}
+
+	values := []interface{}{}
+	switch operator {
+	case "exists", "!exists":
+	case "==", "!=", "=~", "!~":
+		value := parser.next()
+		if len(value) == 0 || strings.ContainsAny(value, "(),") {
+			return "", nil, fmt.Errorf("ecs_state: expected a value after %q %s in expression", subject, operator)
+		}
+		values = append(values, value)
+	case "in", "not_in":
+		if err := parser.expect("("); err != nil {
+			return "", nil, err
+		}
+		for {
+			value := parser.next()
+			if len(value) == 0 || strings.ContainsAny(value, "(),") {
+				return "", nil, fmt.Errorf("ecs_state: expected a value in list after %q %s in expression", subject, operator)
+			}
+			values = append(values, value)
+			if separator := parser.next(); separator == ")" {
+				break
+			} else if separator != "," {
+				return "", nil, fmt.Errorf("ecs_state: expected \",\" or \")\" in list but found %q", separator)
+			}
+		}
+	default:
+		return "", nil, fmt.Errorf("ecs_state: unsupported operator %q in expression", operator)
+	}
+
+	if strings.HasPrefix(subject, "attribute:") {
+		return attributeCondition(strings.TrimPrefix(subject, "attribute:"), operator, values)
+	}
+	column, ok := expressionColumns[subject]
+	if !ok {
+		return "", nil, fmt.Errorf("ecs_state: unsupported subject %q in expression", subject)
+	}
+	if column == "agent_connected" {
+		for i, value := range values {
+			connected, err := strconv.ParseBool(value.(string))
+			if err != nil {
+				return "", nil, fmt.Errorf("ecs_state: agentConnected must be compared with true or false, not %q", value)
+			}
+			values[i] = connected
+		}
+	}
+	return columnCondition(expressionInstances+"."+column, operator, values)
+}
+
+// The SQL condition comparing a ContainerInstance column with values.
+func columnCondition(column string, operator string, values []interface{}) (string, []interface{}, error) {
+	switch operator {
+	case "exists":
+		return fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", column, column), nil, nil
+	case "!exists":
+		return fmt.Sprintf("(%s IS NULL OR %s = '')", column, column), nil, nil
+	}
+	if _, connected := values[0].(bool); connected && operator != "==" && operator != "!=" {
+		return "", nil, fmt.Errorf("ecs_state: agentConnected only supports == and !=")
+	}
+	return column + " " + valueCondition(operator, len(values)), values, nil
+}
+
+// The SQL condition matching ContainerInstances by one of their Attributes, correlated with the ContainerInstance
+// being filtered.  Negated operators also match instances without the attribute at all, as ECS does.
+func attributeCondition(name string, operator string, values []interface{}) (string, []interface{}, error) {
+	attributes := expressionAttributes
+	query := fmt.Sprintf("SELECT 1 FROM %s WHERE %s.container_instance_a_r_n = %s.a_r_n AND %s.name = ?",
+		attributes, attributes, expressionInstances, attributes)
+	args := []interface{}{name}
+
+	negated := false
+	switch operator {
+	case "exists":
+	case "!exists":
+		negated = true
+	case "!=", "!~", "not_in":
+		negated = true
+		operator = map[string]string{"!=": "==", "!~": "=~", "not_in": "in"}[operator]
+		fallthrough
+	default:
+		query += " AND " + attributes + ".value " + valueCondition(operator, len(values))
+		args = append(args, values...)
+	}
+
+	if negated {
+		return "NOT EXISTS (" + query + ")", args, nil
+	}
+	return "EXISTS (" + query + ")", args, nil
+}
+
+// The SQL comparison, without its left hand side, for a value operator taking count values.
+func valueCondition(operator string, count int) string {
+	switch operator {
+	case "==":
+		return "= ?"
+	case "!=":
+		return "<> ?"
+	case "=~":
+		return "GLOB ?"
+	case "!~":
+		return "NOT GLOB ?"
+	case "not_in":
+		return "NOT IN (" + strings.TrimSuffix(strings.Repeat("?,", count), ",") + ")"
+	}
+	return "IN (" + strings.TrimSuffix(strings.Repeat("?,", count), ",") + ")"
+}
@@ -0,0 +1,98 @@
+package ecs_state_test
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+)
+
+// Sets the Attributes of a ContainerInstance built by containerInstance from name and value pairs.
+func withAttributes(containerInstance *ecs.ContainerInstance, nameValues ...string) *ecs.ContainerInstance {
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		containerInstance.Attributes = append(containerInstance.Attributes, &ecs.Attribute{Name: aws.String(nameValues[i]), Value: aws.String(nameValues[i+1])})
+	}
+	return containerInstance
+}
+
+func TestFindLocationsForTaskDefinitionWithExpression(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client,
+		withAttributes(containerInstance("a", 2048, 2048), "ecs.instance-type", "m5.large", "az", "us-east-1a"),
+		withAttributes(containerInstance("b", 2048, 2048), "ecs.instance-type", "M5.large", "az", "us-east-1b"),
+		withAttributes(containerInstance("c", 2048, 2048), "az", "us-east-1a"))
+	expectTaskDefinitions(client, taskDefinition("web", 256, 256))
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+
+	for expression, want := range map[string]string{
+		// Patterns are wildcards, matched case-sensitively
+		"attribute:ecs.instance-type =~ m5.*":      "a",
+		"attribute:ecs.instance-type matches m5.?": "",
+		// Negated attribute operators also match instances without the attribute
+		"attribute:ecs.instance-type !~ m5.*":            "bc",
+		"attribute:ecs.instance-type != m5.large":        "bc",
+		"attribute:ecs.instance-type exists":             "ab",
+		"attribute:ecs.instance-type !exists":            "c",
+		"attribute:ecs.instance-type not_exists":         "c",
+		"attribute:az in (us-east-1b, us-east-1c)":       "b",
+		"attribute:az not_in (us-east-1b)":               "ac",
+		"ec2InstanceId in (i-a, i-c)":                    "ac",
+		"agentConnected == true && ec2InstanceId != i-a": "bc",
+		// and binds more tightly than or
+		"ec2InstanceId == i-a or ec2InstanceId == i-b and attribute:az == us-east-1b":   "ab",
+		"(ec2InstanceId == i-a || ec2InstanceId == i-b) and attribute:az == us-east-1b": "b",
+		"not ec2InstanceId == i-a":                                    "bc",
+		"not (attribute:az == us-east-1a and agentConnected == true)": "b",
+		"not attribute:az == us-east-1a or ec2InstanceId == i-c":      "bc",
+	} {
+		locations, err := state.FindLocationsForTaskDefinitionWithExpression("web:1", expression)
+		if err != nil {
+			t.Errorf("%s: %v", expression, err)
+			continue
+		}
+		found := []string{}
+		for _, location := range *locations {
+			found = append(found, strings.TrimPrefix(location.EC2InstanceId, "i-"))
+		}
+		sort.Strings(found)
+		if strings.Join(found, "") != want {
+			t.Errorf("%s found %v, want %q", expression, found, want)
+		}
+	}
+}
+
+func TestCompileExpressionErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"ec2InstanceId",
+		"ec2InstanceId ==",
+		"ec2InstanceId = i-a",
+		"ec2InstanceId >> i-a",
+		"instanceType == m5.large",
+		"agentConnected == maybe",
+		"agentConnected =~ t*",
+		"attribute:az in us-east-1a",
+		"attribute:az in (us-east-1a us-east-1b)",
+		"(ec2InstanceId == i-a",
+		"ec2InstanceId == i-a)",
+		"ec2InstanceId == i-a and",
+	} {
+		if filter, err := ecs_state.CompileExpression(expression); err == nil || filter != nil {
+			t.Errorf("compiled %q without an error", expression)
+		}
+	}
+
+	state := newTestState(t, mocks.NewECSAPI(t), ecs_state.Options{})
+	locations, err := state.FindLocationsForTaskDefinitionWithExpression("web:1", "ec2InstanceId ==")
+	if err == nil || !reflect.DeepEqual(*locations, []ecs_state.ContainerInstance{}) {
+		t.Errorf("found %v with error %v, want no locations and the compile error", *locations, err)
+	}
+}
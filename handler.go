@@ -0,0 +1,92 @@
+package ecs_state
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Returns an http.Handler serving the local state as JSON DTOs from the read-only endpoints /clusters, /instances,
+// /tasks, and /placement?td=family.  Use http.StripPrefix when mounting it under a prefix.
+func (state *State) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clusters", func(w http.ResponseWriter, r *http.Request) {
+		state.serveQuery(w, func(db *gorm.DB) (interface{}, error) {
+			clusters := []Cluster{}
+			dtos := []ClusterDTO{}
+			err := db.Find(&clusters).Error
+			for _, cluster := range clusters {
+				dtos = append(dtos, cluster.ToDTO())
+			}
+			return dtos, err
+		})
+	})
+	mux.HandleFunc("/instances", func(w http.ResponseWriter, r *http.Request) {
+		state.serveQuery(w, func(db *gorm.DB) (interface{}, error) {
+			containerInstances := []ContainerInstance{}
+			err := db.Find(&containerInstances).Error
+			return containerInstanceDTOs(containerInstances), err
+		})
+	})
+	mux.HandleFunc("/tasks", func(w http.ResponseWriter, r *http.Request) {
+		state.serveQuery(w, func(db *gorm.DB) (interface{}, error) {
+			tasks := []Task{}
+			dtos := []TaskDTO{}
+			err := db.Find(&tasks).Error
+			for _, task := range tasks {
+				dtos = append(dtos, task.ToDTO())
+			}
+			return dtos, err
+		})
+	})
+	mux.HandleFunc("/placement", func(w http.ResponseWriter, r *http.Request) {
+		td := r.URL.Query().Get("td")
+		if len(td) == 0 {
+			http.Error(w, "missing td parameter", http.StatusBadRequest)
+			return
+		}
+		if _, err := state.FindTaskDefinition(td); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// A failed auto refresh has been logged, and the locations are found in local state as it was, just as
+		// FindLocationsForTaskDefinition does.
+		containerInstances, _ := state.FindLocationsForTaskDefinitionWithContext(r.Context(), td)
+		state.writeJSON(w, containerInstanceDTOs(*containerInstances))
+	})
+	return mux
+}
+
+// Runs query against the read-only database and writes the result it returns as JSON, or an internal server error if
+// either fails.
+func (state *State) serveQuery(w http.ResponseWriter, query func(*gorm.DB) (interface{}, error)) {
+	db, err := state.ReadOnlyDB()
+	var result interface{}
+	if err == nil {
+		result, err = query(db)
+	}
+	if err != nil {
+		state.log.Error("Unable to serve state:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	state.writeJSON(w, result)
+}
+
+// Writes result as the JSON body of a response.
+func (state *State) writeJSON(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		state.log.Error("Unable to write state:", err)
+	}
+}
+
+// Converts ContainerInstances to their DTOs.
+func containerInstanceDTOs(containerInstances []ContainerInstance) []ContainerInstanceDTO {
+	dtos := []ContainerInstanceDTO{}
+	for _, containerInstance := range containerInstances {
+		dtos = append(dtos, containerInstance.ToDTO())
+	}
+	return dtos
+}
@@ -0,0 +1,95 @@
+package ecs_state_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+)
+
+// Gets path from handler, decoding the JSON response into result, and returns the status code.
+func getJSON(t *testing.T, handler http.Handler, path string, result interface{}) int {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	if recorder.Code == http.StatusOK {
+		if strings.Contains(recorder.Body.String(), "==") {
+			t.Errorf("%s exposed the internal port format: %s", path, recorder.Body.String())
+		}
+		if err := json.NewDecoder(recorder.Body).Decode(result); err != nil {
+			t.Fatalf("decoding %s: %v", path, err)
+		}
+	}
+	return recorder.Code
+}
+
+func TestHandler(t *testing.T) {
+	web := task("web", "web", "a")
+	web.Containers = []*ecs.Container{{NetworkBindings: []*ecs.NetworkBinding{
+		{HostPort: aws.Int64(80), Protocol: aws.String(ecs.TransportProtocolTcp)},
+		{HostPort: aws.Int64(53), Protocol: aws.String(ecs.TransportProtocolUdp)},
+	}}}
+
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, withUDPPorts(containerInstance("a", 2048, 2048, "80"), "53"), containerInstance("b", 2048, 2048))
+	expectTasks(client, web)
+	expectTaskDefinitions(client, taskDefinition("web", 256, 256, portMapping(80, ecs.TransportProtocolTcp)))
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshAll(); err != nil {
+		t.Fatal(err)
+	}
+	handler := state.Handler()
+
+	clusters := []ecs_state.ClusterDTO{}
+	if code := getJSON(t, handler, "/clusters", &clusters); code != http.StatusOK || len(clusters) != 1 || clusters[0].ARN != testClusterARN {
+		t.Errorf("/clusters returned %d %+v, want the test cluster", code, clusters)
+	}
+	instances := []ecs_state.ContainerInstanceDTO{}
+	if code := getJSON(t, handler, "/instances", &instances); code != http.StatusOK || len(instances) != 2 {
+		t.Fatalf("/instances returned %d %+v, want both instances", code, instances)
+	}
+	for _, instance := range instances {
+		if instance.EC2InstanceId == "i-a" && (!reflect.DeepEqual(instance.RemainingTCPPorts, []int32{22, 80}) || !reflect.DeepEqual(instance.RemainingUDPPorts, []int32{53})) {
+			t.Errorf("/instances served ports TCP %v UDP %v, want [22 80] and [53]", instance.RemainingTCPPorts, instance.RemainingUDPPorts)
+		}
+	}
+	tasks := []ecs_state.TaskDTO{}
+	if code := getJSON(t, handler, "/tasks", &tasks); code != http.StatusOK || len(tasks) != 1 {
+		t.Fatalf("/tasks returned %d %+v, want the web task", code, tasks)
+	}
+	if !reflect.DeepEqual(tasks[0].TCPPorts, []int32{80}) || !reflect.DeepEqual(tasks[0].UDPPorts, []int32{53}) {
+		t.Errorf("/tasks served ports TCP %v UDP %v, want [80] and [53]", tasks[0].TCPPorts, tasks[0].UDPPorts)
+	}
+
+	locations := []ecs_state.ContainerInstanceDTO{}
+	if code := getJSON(t, handler, "/placement?td=web:1", &locations); code != http.StatusOK || len(locations) != 1 || locations[0].EC2InstanceId != "i-b" {
+		t.Errorf("/placement returned %d %+v, want only i-b", code, locations)
+	}
+	if code := getJSON(t, handler, "/placement", nil); code != http.StatusBadRequest {
+		t.Errorf("/placement without td returned %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := getJSON(t, handler, "/placement?td=missing:1", nil); code != http.StatusInternalServerError {
+		t.Errorf("/placement of an unknown definition returned %d, want %d", code, http.StatusInternalServerError)
+	}
+}
+
+func TestHandlerPlacementAutoRefreshes(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 2048, 2048))
+	expectTasks(client)
+	expectTaskDefinitions(client, taskDefinition("web", 256, 256))
+	state := newTestState(t, client, ecs_state.Options{AutoRefreshAge: time.Minute})
+
+	// Nothing has been refreshed, so placement refreshes first as FindLocationsForTaskDefinition would
+	locations := []ecs_state.ContainerInstanceDTO{}
+	if code := getJSON(t, state.Handler(), "/placement?td=web:1", &locations); code != http.StatusOK || len(locations) != 1 {
+		t.Errorf("/placement returned %d %+v, want the instance found by an auto refresh", code, locations)
+	}
+}
@@ -0,0 +1,17 @@
+package ecs_state
+
+// A single host port of a ContainerInstance, stored by gorm when Options.NormalizedPorts is set so that ports can be
+// queried relationally rather than through the searchable string columns.  Protocol is "tcp" or "udp", and InUse is
+// set for ports ECS reports as taken, while ports the instance registered but are free again are kept with InUse unset.
+type InstancePort struct {
+	ID                   int    `gorm:"primary_key"`
+	ContainerInstanceARN string `sql:"size:1024;index"`
+	Protocol             string
+	Port                 int `sql:"index"`
+	InUse                bool
+}
+
+// The table InstancePorts are stored in, before any Options.TablePrefix.
+func (InstancePort) TableName() string {
+	return "instance_ports"
+}
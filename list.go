@@ -0,0 +1,54 @@
+package ecs_state
+
+import "github.com/jinzhu/gorm"
+
+// Returns the ContainerInstances in local state ordered by ARN.  An optional ListOptions pages through
+// the results or loads the Tasks of each instance.
+func (state *State) ListContainerInstances(options ...ListOptions) ([]ContainerInstance, error) {
+	state.log.Info("entering ListContainerInstances()")
+	listOptions := ListOptions{}
+	if len(options) > 0 {
+		listOptions = options[0]
+	}
+
+	query := state.paginate(state.DB().Order("a_r_n"), listOptions)
+	if listOptions.PreloadTasks {
+		query = query.Preload("Tasks")
+	}
+	containerInstances := []ContainerInstance{}
+	err := query.Find(&containerInstances).Error
+	return containerInstances, err
+}
+
+// Returns the ARNs of the ContainerInstances in local state in order, selecting only that column, for example to
+// compare the cluster against an Auto Scaling group without loading every instance.
+func (state *State) ListContainerInstanceARNs() ([]string, error) {
+	state.log.Info("entering ListContainerInstanceARNs()")
+	arns := []string{}
+	err := state.DB().Model(&ContainerInstance{}).Order("a_r_n").Pluck("a_r_n", &arns).Error
+	return arns, err
+}
+
+// Returns the Tasks in local state ordered by ARN.  An optional ListOptions pages through the results.
+func (state *State) ListTasks(options ...ListOptions) ([]Task, error) {
+	state.log.Info("entering ListTasks()")
+	listOptions := ListOptions{}
+	if len(options) > 0 {
+		listOptions = options[0]
+	}
+
+	tasks := []Task{}
+	err := state.paginate(state.DB().Order("a_r_n"), listOptions).Find(&tasks).Error
+	return tasks, err
+}
+
+// Applies the Limit and Offset of a ListOptions to a query.
+func (state *State) paginate(query *gorm.DB, options ListOptions) *gorm.DB {
+	if options.Limit > 0 {
+		query = query.Limit(options.Limit)
+	}
+	if options.Offset > 0 {
+		query = query.Offset(options.Offset)
+	}
+	return query
+}
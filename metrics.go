@@ -0,0 +1,26 @@
+package ecs_state
+
+import "time"
+
+// The kinds of entity refreshed from ECS, reported to a MetricsObserver.
+const (
+	RefreshKindCluster            = "cluster"
+	RefreshKindContainerInstances = "container_instances"
+	RefreshKindTasks              = "tasks"
+	RefreshKindServices           = "services"
+)
+
+// Receives timing and volume information about every refresh so that it can be exported to a metrics library
+// of your choice, such as Prometheus.  Provide one through Options.Metrics.
+type MetricsObserver interface {
+	// Called at the end of each refresh with the kind of entity refreshed, how long the refresh took, how many
+	// entities it processed, and the error it returned, if any.
+	ObserveRefresh(kind string, duration time.Duration, count int, err error)
+}
+
+// Reports a finished refresh to the configured MetricsObserver, if there is one.
+func (state *State) observeRefresh(kind string, start time.Time, count int, err error) {
+	if state.options.Metrics != nil {
+		state.options.Metrics.ObserveRefresh(kind, state.now().Sub(start), count, err)
+	}
+}
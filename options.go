@@ -0,0 +1,194 @@
+package ecs_state
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The number of parallel DescribeTaskDefinition calls FindTaskDefinitions makes when not configured.
+const defaultTaskDefinitionConcurrency = 5
+
+// How long a bare family name resolves to the same revision when not configured.
+const defaultFamilyAliasTTL = time.Minute
+
+// The ephemeral port range of the Linux kernel, from which Docker binds dynamic host ports by default.
+var defaultDynamicPortRange = PortRange{Low: 32768, High: 60999}
+
+// Optional settings for a State, provided to InitializeWithOptions.  The zero value matches the behavior of Initialize.
+type Options struct {
+	// Prepended to the name of every table ecs_state creates and queries, for example "ecsstate_" to store Tasks in
+	// ecsstate_tasks, so that the tables do not collide with other models sharing the database.
+	TablePrefix string
+
+	// How long a cached TaskDefinition is trusted before FindTaskDefinition describes it from ECS again.
+	// Zero caches definitions forever.
+	TaskDefinitionTTL time.Duration
+
+	// When set, containers not marked essential, such as logging sidecars, are left out of the CPU and memory
+	// a TaskDefinition requires for placement.
+	ExcludeNonEssentialContainers bool
+
+	// How long a TaskDefinition looked up by bare family name, such as my_app, keeps resolving to the revision ECS
+	// last returned for it before the family is described again to catch new revisions.  Defaults to one minute.
+	FamilyAliasTTL time.Duration
+
+	// The most DescribeTaskDefinition calls FindTaskDefinitions makes at once.  Defaults to 5.
+	TaskDefinitionConcurrency int
+
+	// How long a ContainerInstance or Task may go unseen by refreshes before it is removed from local state.
+	// Zero removes anything missing from the latest refresh immediately.
+	StaleRecordTTL time.Duration
+
+	// How long a Task seen as STOPPED is kept after it stopped, even once refreshes no longer return it, so that
+	// its StoppedReason can still be queried.  When set, unfiltered Task refreshes also list STOPPED Tasks, which ECS
+	// otherwise leaves out.  Zero leaves stopped Tasks to the StaleRecordTTL like any other.
+	StoppedTaskRetention time.Duration
+
+	// When set, the final state of every Task removed from local state is recorded as TaskHistory, for use with
+	// TaskHistoryBetween.  Off by default as the history otherwise grows without bound.
+	TaskHistory bool
+
+	// How long TaskHistory is kept after the Task was removed.  Zero keeps it forever.
+	TaskHistoryRetention time.Duration
+
+	// When set, refreshes request the tags of Tasks and ContainerInstances from ECS and store them as Tags, for use
+	// with FindTasksByTag.  Off by default as including tags increases the cost of the describe calls.
+	IncludeTags bool
+
+	// Additional fields requested from DescribeContainerInstances by refreshes, such as CONTAINER_INSTANCE_HEALTH.
+	// TAGS is requested whenever IncludeTags is set, and attributes are always returned by ECS.
+	ContainerInstanceInclude []string
+
+	// When set, the host ports of every ContainerInstance are also kept in a normalized InstancePort table and placement
+	// queries check ports through it.  The string port columns are maintained either way.
+	NormalizedPorts bool
+
+	// The host ports Docker chooses from for port mappings without a host port under bridge networking.  Placement
+	// requires enough of the range to be free for such mappings.  Defaults to 32768 through 60999.
+	DynamicPortRange PortRange
+
+	// How long a task registered with RegisterPendingTask holds its resources if it is never confirmed or
+	// replaced by a refresh.  Zero holds them until then.
+	PendingTaskTTL time.Duration
+
+	// When set, placement queries first refresh ContainerInstances and Tasks if either last refreshed successfully
+	// longer ago than this, giving reasonably fresh placement without a separate refresh loop.  Concurrent queries
+	// share a single refresh.  Zero always queries local state as it is.
+	AutoRefreshAge time.Duration
+
+	// How long ago the cluster, ContainerInstances and Tasks may each have last refreshed successfully before Healthy
+	// reports the State as stale.  Zero only checks that the database is reachable.
+	MaxRefreshAge time.Duration
+
+	// Called after each refresh of ContainerInstances or Tasks with every row it inserted, updated, or deleted, and
+	// after RemoveContainerInstance or RemoveTask with the rows they deleted.
+	OnChange func([]StateChange)
+
+	// When set, refreshes work out and log the changes they would make, reporting them to OnChange as usual, but
+	// leave local state untouched.  Useful to trial ecs_state against a production cluster.
+	DryRun bool
+
+	// What a refresh records as the remaining CPU or memory of a ContainerInstance when ECS omits it, which it
+	// occasionally does transiently.  Defaults to zero, excluding the instance from placement until ECS reports it.
+	MissingResources ResourceFallback
+
+	// Called once the tables and default indexes have been created, for example to add indexes suited to the
+	// application's own queries with AddIndex.  Queries through the database given use the TablePrefix, but schema
+	// changes must name the prefixed table, as in db.Table(TablePrefix + "tasks").AddIndex(...).
+	AfterMigrate func(*gorm.DB)
+
+	// Notified at the end of every refresh, allowing refresh timing and counts to be monitored.
+	Metrics MetricsObserver
+
+	// The source of the current time.  Defaults to the real time.
+	Clock Clock
+}
+
+// An inclusive range of port numbers.
+type PortRange struct {
+	Low  int
+	High int
+}
+
+// Optional settings for a single placement query, provided to FindLocationsForTaskDefinitionWithOptions.
+// The zero value matches the behavior of FindLocationsForTaskDefinition.
+type PlacementOptions struct {
+	// Multiplies an instance's remaining CPU before comparing it with the TaskDefinition, for example 1.5 to
+	// overcommit CPU by half.  Zero is treated as 1.
+	CPUFactor float64
+	// Multiplies an instance's remaining memory before comparing it with the TaskDefinition.  Overcommitting
+	// memory is riskier than CPU, so this is kept separate.  Zero is treated as 1.
+	MemoryFactor float64
+	// Extra CPU units treated as available on every instance, applied after CPUFactor.
+	CPUHeadroom int
+	// Extra memory treated as available on every instance, applied after MemoryFactor.
+	MemoryHeadroom int
+	// Allows instances in the DRAINING status to be returned.  ECS never places new tasks on draining
+	// instances, so they are excluded by default.
+	IncludeDraining bool
+	// The order candidate instances are returned in.  Defaults to the database's order, or LeastFreeCPU for
+	// BestInstanceForTaskDefinition.
+	Order PlacementOrder
+	// Returns at most this many candidate instances, the first ones under Order, for example the 50 most tightly
+	// packed with LeastFreeCPU.  Zero returns every candidate.
+	Limit int
+	// Restricts candidates to the instances of the named capacity provider, for example to place only on spot
+	// capacity.  Empty allows any instance.
+	CapacityProvider string
+}
+
+// The order FindLocationsForTaskDefinitionWithOptions returns candidate ContainerInstances in, and by which
+// BestInstanceForTaskDefinition ranks them.
+type PlacementOrder int
+
+const (
+	// Leaves the order of candidates to the database.
+	Unordered PlacementOrder = iota
+	// Most remaining CPU first, spreading tasks across the cluster.
+	MostFreeCPU
+	// Least remaining CPU first, packing tasks as tightly as possible.
+	LeastFreeCPU
+	// Most remaining memory first.
+	MostFreeMemory
+	// Least remaining memory first.
+	LeastFreeMemory
+)
+
+// The value used in place of a remaining resource ECS did not report for a ContainerInstance.
+type ResourceFallback int
+
+const (
+	// Treats the resource as exhausted.
+	ResourceFallbackZero ResourceFallback = iota
+	// Treats the instance as empty, with all of its registered resource remaining.
+	ResourceFallbackRegistered
+	// Keeps the remaining value from the previous refresh, or zero for an instance not seen before.
+	ResourceFallbackLastKnown
+)
+
+// The ORDER BY clause for the PlacementOrder, or empty if unordered.  Ties are broken by the other resource, then by
+// ARN, so results are stable.
+func (order PlacementOrder) clause() string {
+	switch order {
+	case MostFreeCPU:
+		return "remaining_cpu DESC, remaining_memory DESC, a_r_n"
+	case LeastFreeCPU:
+		return "remaining_cpu ASC, remaining_memory ASC, a_r_n"
+	case MostFreeMemory:
+		return "remaining_memory DESC, remaining_cpu DESC, a_r_n"
+	case LeastFreeMemory:
+		return "remaining_memory ASC, remaining_cpu ASC, a_r_n"
+	}
+	return ""
+}
+
+// Optional pagination and preloading for ListContainerInstances and ListTasks.  The zero value returns every row.
+type ListOptions struct {
+	// The most rows to return, or zero for no limit.
+	Limit int
+	// The number of rows to skip, for paging through results together with Limit.
+	Offset int
+	// Loads the Tasks of each ContainerInstance.  Ignored by ListTasks.
+	PreloadTasks bool
+}
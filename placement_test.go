@@ -0,0 +1,299 @@
+package ecs_state_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+)
+
+func TestBestInstanceForTaskDefinitionOrders(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client,
+		containerInstance("packed", 512, 3072),
+		containerInstance("balanced", 2048, 2048),
+		containerInstance("empty", 3072, 512),
+	)
+	expectTaskDefinitions(client, taskDefinition("web", 256, 256))
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+
+	for order, want := range map[ecs_state.PlacementOrder]string{
+		ecs_state.Unordered:       "i-packed",
+		ecs_state.LeastFreeCPU:    "i-packed",
+		ecs_state.MostFreeCPU:     "i-empty",
+		ecs_state.LeastFreeMemory: "i-empty",
+		ecs_state.MostFreeMemory:  "i-packed",
+	} {
+		best, err := state.BestInstanceForTaskDefinition("web:1", ecs_state.PlacementOptions{Order: order})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if best.EC2InstanceId != want {
+			t.Errorf("order %d chose %s, want %s", order, best.EC2InstanceId, want)
+		}
+	}
+
+	// Other placement options still constrain the candidates.
+	best, err := state.BestInstanceForTaskDefinition("web:1", ecs_state.PlacementOptions{Order: ecs_state.LeastFreeCPU, CPUHeadroom: -512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best.EC2InstanceId != "i-balanced" {
+		t.Errorf("chose %s with CPU headroom, want i-balanced", best.EC2InstanceId)
+	}
+	if _, err := state.BestInstanceForTaskDefinition("web:1", ecs_state.PlacementOptions{CPUHeadroom: -4096}); err != ecs_state.ErrInsufficientCapacity {
+		t.Errorf("expected ErrInsufficientCapacity when nothing fits, got %v", err)
+	}
+}
+
+func TestPlacementDuringRefresh(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 2048, 2048), containerInstance("b", 1024, 1024))
+	expectTasks(client, task("web", "web", "a"))
+	expectTaskDefinitions(client, taskDefinition("web", 256, 256))
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshAll(); err != nil {
+		t.Fatal(err)
+	}
+	readOnly, err := state.ReadOnlyDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed := make(chan error, 1)
+	go func() {
+		for i := 0; i < 20; i++ {
+			if err := state.RefreshAll(); err != nil {
+				refreshed <- err
+				return
+			}
+		}
+		close(refreshed)
+	}()
+	for done := false; !done; {
+		select {
+		case err, ok := <-refreshed:
+			if ok {
+				t.Fatal(err)
+			}
+			done = true
+		default:
+		}
+		if _, err := state.BestInstanceForTaskDefinition("web:1", ecs_state.PlacementOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		id, err := state.Reserve("arn:aws:ecs:us-east-1:123456789012:container-instance/test/a", "web:1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		state.Release(id)
+		if err := readOnly.Find(&[]ecs_state.Task{}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDynamicPortRangeNearlyExhausted(t *testing.T) {
+	const instanceARN = "arn:aws:ecs:us-east-1:123456789012:container-instance/test/a"
+	// Of the four dynamic ports, ECS reports 50002 reserved and leaves out the two it bound for the running Task
+	running := task("web", "web", "a")
+	running.Containers = []*ecs.Container{{NetworkBindings: []*ecs.NetworkBinding{
+		{HostPort: aws.Int64(50000), Protocol: aws.String(ecs.TransportProtocolTcp)},
+		{HostPort: aws.Int64(50001), Protocol: aws.String(ecs.TransportProtocolTcp)},
+	}}}
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 4096, 4096, "50002"))
+	expectTasks(client, running)
+	expectTaskDefinitions(client,
+		taskDefinition("one", 256, 256, portMapping(0, "tcp")),
+		taskDefinition("two", 256, 256, portMapping(0, "tcp"), portMapping(0, "tcp")),
+		taskDefinition("fixed", 256, 256, portMapping(50003, "tcp")),
+	)
+	state := newTestState(t, client, ecs_state.Options{DynamicPortRange: ecs_state.PortRange{Low: 50000, High: 50003}})
+	if err := state.RefreshAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	if locations := *state.FindLocationsForTaskDefinition("one:1"); len(locations) != 1 {
+		t.Errorf("found %d locations for the last free dynamic port, want 1", len(locations))
+	}
+	if locations := *state.FindLocationsForTaskDefinition("two:1"); len(locations) != 0 {
+		t.Errorf("found %d locations for two dynamic ports with one free, want none", len(locations))
+	}
+	locations, err := state.FindLocationsForTaskDefinitions([]string{"one:1", "one:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locations) != 0 {
+		t.Errorf("found %d locations to co-locate two dynamic ports with one free, want none", len(locations))
+	}
+	explanation, err := state.ExplainPlacement("two:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explanation.InsufficientDynamicTCPPorts != 1 || explanation.Candidates != 0 {
+		t.Errorf("explained %+v, want the instance ruled out for dynamic TCP ports", explanation)
+	}
+
+	// A fixed port within the dynamic range takes the last port that could have been bound dynamically
+	id, err := state.Reserve(instanceARN, "fixed:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locations := *state.FindLocationsForTaskDefinition("one:1"); len(locations) != 0 {
+		t.Errorf("found %d locations for a dynamic port once the range was reserved, want none", len(locations))
+	}
+	state.Release(id)
+	if locations := *state.FindLocationsForTaskDefinition("one:1"); len(locations) != 1 {
+		t.Errorf("found %d locations for the dynamic port released, want 1", len(locations))
+	}
+}
+
+func TestFindLocationsForTaskDefinitionSplitProtocols(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client,
+		withUDPPorts(containerInstance("udp-taken", 4096, 4096), "53"),
+		containerInstance("free", 4096, 4096),
+		containerInstance("tcp-taken", 4096, 4096, "53"),
+	)
+	expectTaskDefinitions(client,
+		taskDefinition("dns", 256, 256, portMapping(53, "tcp"), portMapping(53, "udp")),
+		taskDefinition("dns-udp", 256, 256, portMapping(53, "udp")),
+	)
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+
+	// TCP 53 and UDP 53 are separate ports, so each protocol is only constrained by its own column
+	for td, want := range map[string][]string{
+		"dns:1":     {"free"},
+		"dns-udp:1": {"free", "tcp-taken"},
+	} {
+		got := instanceARNs(*state.FindLocationsForTaskDefinition(td))
+		wantARNs := []string{}
+		for _, id := range want {
+			wantARNs = append(wantARNs, "arn:aws:ecs:us-east-1:123456789012:container-instance/test/"+id)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, wantARNs) {
+			t.Errorf("found %v for %s, want %v", got, td, wantARNs)
+		}
+	}
+}
+
+func TestFindLocationsForTaskDefinitionOvercommit(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client,
+		containerInstance("roomy", 2048, 2048),
+		containerInstance("cpu", 700, 2048),
+		containerInstance("memory", 2048, 700),
+	)
+	expectTaskDefinitions(client, taskDefinition("web", 1024, 1024))
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, test := range map[string]struct {
+		options ecs_state.PlacementOptions
+		want    []string
+	}{
+		"no overcommit":   {ecs_state.PlacementOptions{}, []string{"i-roomy"}},
+		"CPU factor":      {ecs_state.PlacementOptions{CPUFactor: 1.5}, []string{"i-cpu", "i-roomy"}},
+		"memory factor":   {ecs_state.PlacementOptions{MemoryFactor: 1.5}, []string{"i-memory", "i-roomy"}},
+		"both factors":    {ecs_state.PlacementOptions{CPUFactor: 1.5, MemoryFactor: 1.5}, []string{"i-cpu", "i-memory", "i-roomy"}},
+		"CPU headroom":    {ecs_state.PlacementOptions{CPUHeadroom: 324}, []string{"i-cpu", "i-roomy"}},
+		"memory headroom": {ecs_state.PlacementOptions{MemoryHeadroom: 323}, []string{"i-roomy"}},
+		"undercommit":     {ecs_state.PlacementOptions{CPUFactor: 0.25}, []string{}},
+	} {
+		locations := state.FindLocationsForTaskDefinitionWithOptions("web:1", test.options)
+		got := []string{}
+		for _, location := range *locations {
+			got = append(got, location.EC2InstanceId)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: found %v, want %v", name, got, test.want)
+		}
+	}
+}
+
+func TestFindLocationsForTaskDefinitionExcludesDraining(t *testing.T) {
+	draining := containerInstance("draining", 4096, 4096)
+	draining.Status = aws.String(ecs.ContainerInstanceStatusDraining)
+
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, draining, containerInstance("active", 512, 512))
+	expectTaskDefinitions(client, taskDefinition("web", 256, 256))
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := instanceARNs(*state.FindLocationsForTaskDefinition("web:1")); !reflect.DeepEqual(got, []string{*containerInstance("active", 0, 0).ContainerInstanceArn}) {
+		t.Errorf("found %v, want only the active instance", got)
+	}
+	included := instanceARNs(*state.FindLocationsForTaskDefinitionWithOptions("web:1", ecs_state.PlacementOptions{IncludeDraining: true}))
+	sort.Strings(included)
+	if !reflect.DeepEqual(included, []string{*containerInstance("active", 0, 0).ContainerInstanceArn, *draining.ContainerInstanceArn}) {
+		t.Errorf("found %v with IncludeDraining, want both instances", included)
+	}
+}
+
+func TestNormalizedPortsPlaceIdentically(t *testing.T) {
+	definitions := []*ecs.TaskDefinition{
+		taskDefinition("web", 256, 256, portMapping(80, ecs.TransportProtocolTcp)),
+		taskDefinition("dns", 256, 256, portMapping(53, ecs.TransportProtocolUdp)),
+		taskDefinition("both", 256, 256, portMapping(8080, ecs.TransportProtocolTcp), portMapping(8080, ecs.TransportProtocolUdp)),
+		taskDefinition("dynamic", 256, 256, portMapping(0, ecs.TransportProtocolTcp)),
+		taskDefinition("ssh", 256, 256, portMapping(22, ecs.TransportProtocolTcp)),
+	}
+	states := map[bool]*ecs_state.State{}
+	for _, normalized := range []bool{false, true} {
+		client := mocks.NewECSAPI(t)
+		expectContainerInstances(client,
+			containerInstance("free", 2048, 2048),
+			containerInstance("web", 2048, 2048, "80", "8080"),
+			withUDPPorts(containerInstance("dns", 2048, 2048), "53"),
+			withUDPPorts(containerInstance("udp-8080", 2048, 2048), "8080"),
+		)
+		expectTaskDefinitions(client, definitions...)
+		states[normalized] = newTestState(t, client, ecs_state.Options{NormalizedPorts: normalized})
+		if err := states[normalized].RefreshContainerInstanceState(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, definition := range definitions {
+		td := aws.StringValue(definition.Family) + ":1"
+		want := instanceARNs(*states[false].FindLocationsForTaskDefinition(td))
+		got := instanceARNs(*states[true].FindLocationsForTaskDefinition(td))
+		sort.Strings(want)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: normalized ports found %v, string columns found %v", td, got, want)
+		}
+	}
+
+	// Reservations keep the normalized ports in step with the string columns
+	for normalized, state := range states {
+		if _, err := state.Reserve(*containerInstance("free", 0, 0).ContainerInstanceArn, "web:1"); err != nil {
+			t.Fatalf("reserving with NormalizedPorts %v: %v", normalized, err)
+		}
+	}
+	want := instanceARNs(*states[false].FindLocationsForTaskDefinition("web:1"))
+	got := instanceARNs(*states[true].FindLocationsForTaskDefinition("web:1"))
+	sort.Strings(want)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after a reservation, normalized ports found %v, string columns found %v", got, want)
+	}
+}
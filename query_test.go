@@ -0,0 +1,25 @@
+package ecs_state
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildPortQueryBindsPorts(t *testing.T) {
+	state := &State{}
+	query, args := state.buildPortQuery("remaining_tcp_ports", "80,443")
+	if want := "instr(remaining_tcp_ports, ?) = 0 AND instr(remaining_tcp_ports, ?) = 0"; query != want {
+		t.Errorf("built query %q, want %q", query, want)
+	}
+	if strings.Contains(query, "80") || strings.Contains(query, "443") {
+		t.Errorf("built query %q with ports inline rather than bound", query)
+	}
+	if want := []interface{}{"=80=", "=443="}; !reflect.DeepEqual(args, want) {
+		t.Errorf("bound %v, want %v", args, want)
+	}
+
+	if query, args := state.buildPortQuery("remaining_tcp_ports", ""); query != "" || len(args) != 0 {
+		t.Errorf("built %q with %v for no ports, want nothing", query, args)
+	}
+}
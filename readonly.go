@@ -0,0 +1,53 @@
+package ecs_state
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+)
+
+// The connection behind ReadOnlyDB, passing single SELECT statements through to the database and rejecting anything
+// else with ErrReadOnlyDatabase.  Unlike a pragma, the check cannot be switched off with a statement of its own.
+type readOnlyDB struct {
+	db *sql.DB
+}
+
+func (readOnly readOnlyDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return nil, ErrReadOnlyDatabase
+}
+
+func (readOnly readOnlyDB) Prepare(query string) (*sql.Stmt, error) {
+	if !isSingleSelect(query) {
+		return nil, ErrReadOnlyDatabase
+	}
+	return readOnly.db.Prepare(query)
+}
+
+func (readOnly readOnlyDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	if !isSingleSelect(query) {
+		return nil, ErrReadOnlyDatabase
+	}
+	return readOnly.db.Query(query, args...)
+}
+
+func (readOnly readOnlyDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	if !isSingleSelect(query) {
+		// A Row cannot be built with an error, so the argument fails to convert with it instead
+		return readOnly.db.QueryRow("SELECT ?", readOnlyViolation{})
+	}
+	return readOnly.db.QueryRow(query, args...)
+}
+
+// A query argument whose conversion fails with ErrReadOnlyDatabase.
+type readOnlyViolation struct{}
+
+func (readOnlyViolation) Value() (driver.Value, error) {
+	return nil, ErrReadOnlyDatabase
+}
+
+// Whether query is a single SELECT statement.  SQLite separates statements with semicolons, so any within the query
+// rule it out, even where it would be part of a string literal.
+func isSingleSelect(query string) bool {
+	query = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(query), ";"))
+	return len(query) >= len("SELECT") && strings.EqualFold(query[:len("SELECT")], "SELECT") && !strings.Contains(query, ";")
+}
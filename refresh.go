@@ -0,0 +1,116 @@
+package ecs_state
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Reported by the context aware refresh methods after each page of entities, for example to render a progress bar.
+type RefreshProgress struct {
+	// The kind of entity being refreshed, RefreshKindContainerInstances or RefreshKindTasks.
+	Kind string
+	// How many entities have been processed so far in this refresh.
+	Processed int
+}
+
+// Returns when the given kind of entity, one of RefreshKindCluster, RefreshKindContainerInstances, RefreshKindTasks, or
+// RefreshKindServices, last finished refreshing successfully.  The zero Time is returned if it has never been refreshed.
+// Task refreshes filtered by desired status do not count, as they leave the other Tasks unrefreshed.
+func (state *State) LastRefresh(kind string) (time.Time, error) {
+	switch kind {
+	case RefreshKindCluster, RefreshKindContainerInstances, RefreshKindTasks, RefreshKindServices:
+	default:
+		return time.Time{}, fmt.Errorf("ecs_state: unknown refresh kind %q", kind)
+	}
+
+	state.refreshLock.Lock()
+	defer state.refreshLock.Unlock()
+	return state.lastRefresh[kind], nil
+}
+
+// Records the outcome of a refresh, remembering when it last succeeded and reporting it to any MetricsObserver.
+func (state *State) finishRefresh(kind string, start time.Time, count int, err error) {
+	if err == nil {
+		state.refreshLock.Lock()
+		if state.lastRefresh == nil {
+			state.lastRefresh = map[string]time.Time{}
+		}
+		state.lastRefresh[kind] = state.now()
+		state.refreshLock.Unlock()
+	}
+	state.observeRefresh(kind, start, count, err)
+}
+
+// Readies local state for a placement query, refreshing ContainerInstances and Tasks first if Options.AutoRefreshAge
+// calls for it and then releasing expired pending tasks.  A failed refresh is logged and returned, leaving the query
+// to run against local state as it was.
+func (state *State) preparePlacement(ctx context.Context) error {
+	err := state.refreshIfStale(ctx)
+	if err != nil {
+		state.log.Warn("Auto refresh failed, placing against stale state:", err)
+	}
+	state.releaseExpiredPendingTasks()
+	return err
+}
+
+// Refreshes ContainerInstances and Tasks when either last refreshed successfully longer ago than
+// Options.AutoRefreshAge.  Concurrent callers share one refresh of each kind, as all refreshes do.
+func (state *State) refreshIfStale(ctx context.Context) error {
+	if state.options.AutoRefreshAge <= 0 {
+		return nil
+	}
+	if state.refreshedWithin(RefreshKindContainerInstances, state.options.AutoRefreshAge) &&
+		state.refreshedWithin(RefreshKindTasks, state.options.AutoRefreshAge) {
+		return nil
+	}
+
+	if err := state.RefreshContainerInstanceStateWithContext(ctx, nil); err != nil {
+		return err
+	}
+	return state.RefreshTaskStateWithContext(ctx, nil)
+}
+
+// Whether the given kind of entity last refreshed successfully no longer than maxAge ago.
+func (state *State) refreshedWithin(kind string, maxAge time.Duration) bool {
+	state.refreshLock.Lock()
+	defer state.refreshLock.Unlock()
+	refreshed, ok := state.lastRefresh[kind]
+	return ok && state.now().Sub(refreshed) <= maxAge
+}
+
+// Sends the progress of a refresh, unless no channel was provided or ctx is done first.
+func (state *State) reportProgress(ctx context.Context, progress chan<- RefreshProgress, kind string, processed int) {
+	if progress == nil {
+		return
+	}
+	select {
+	case progress <- RefreshProgress{Kind: kind, Processed: processed}:
+	case <-ctx.Done():
+	}
+}
+
+// Reports whether the State is ready to serve queries, for example from a health check endpoint.  Returns an error if
+// the database cannot be reached or, when Options.MaxRefreshAge is set, if the cluster, its ContainerInstances, or its
+// Tasks have never refreshed successfully or last did so longer ago than that.
+func (state *State) Healthy() error {
+	if err := state.db.DB().Ping(); err != nil {
+		return fmt.Errorf("ecs_state: database unreachable: %v", err)
+	}
+	if state.options.MaxRefreshAge <= 0 {
+		return nil
+	}
+
+	state.refreshLock.Lock()
+	defer state.refreshLock.Unlock()
+	for _, kind := range []string{RefreshKindCluster, RefreshKindContainerInstances, RefreshKindTasks} {
+		refreshed, ok := state.lastRefresh[kind]
+		if !ok {
+			return fmt.Errorf("ecs_state: %s refresh has never succeeded", kind)
+		}
+		if age := state.now().Sub(refreshed); age > state.options.MaxRefreshAge {
+			return fmt.Errorf("ecs_state: %s refresh is stale by %v", kind, age-state.options.MaxRefreshAge)
+		}
+	}
+	return nil
+}
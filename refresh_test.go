@@ -0,0 +1,122 @@
+package ecs_state_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRefreshContainerInstanceStateRollsBackFailedDescribe(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectCluster(client)
+	pages := func(pages ...*ecs.ListContainerInstancesOutput) func(mock.Arguments) {
+		return func(args mock.Arguments) {
+			for i, page := range pages {
+				if !args.Get(1).(func(*ecs.ListContainerInstancesOutput, bool) bool)(page, i == len(pages)-1) {
+					return
+				}
+			}
+		}
+	}
+	first := &ecs.ListContainerInstancesOutput{ContainerInstanceArns: []*string{containerInstance("a", 0, 0).ContainerInstanceArn}}
+	second := &ecs.ListContainerInstancesOutput{ContainerInstanceArns: []*string{containerInstance("b", 0, 0).ContainerInstanceArn}}
+	client.On("ListContainerInstancesPages", mock.Anything, mock.Anything).Run(pages(first)).Return(nil).Once()
+	client.On("DescribeContainerInstances", mock.Anything).Return(&ecs.DescribeContainerInstancesOutput{
+		ContainerInstances: []*ecs.ContainerInstance{containerInstance("a", 2048, 2048)},
+	}, nil).Once()
+	client.On("ListContainerInstancesPages", mock.Anything, mock.Anything).Run(pages(first, second)).Return(nil).Once()
+	client.On("DescribeContainerInstances", mock.Anything).Return(&ecs.DescribeContainerInstancesOutput{
+		ContainerInstances: []*ecs.ContainerInstance{containerInstance("a", 1024, 1024)},
+	}, nil).Once()
+	client.On("DescribeContainerInstances", mock.Anything).Return(nil, errors.New("throttled")).Once()
+
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.RefreshContainerInstanceState(); err == nil {
+		t.Fatal("refresh succeeded despite a failed describe")
+	}
+
+	containerInstances := []ecs_state.ContainerInstance{}
+	if err := state.DB().Find(&containerInstances).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(containerInstances) != 1 {
+		t.Fatalf("kept %d ContainerInstances, want only the one from the first refresh", len(containerInstances))
+	}
+	if containerInstances[0].RemainingCPU != 2048 {
+		t.Errorf("remaining CPU is %d after the failed refresh, want 2048 from the first", containerInstances[0].RemainingCPU)
+	}
+}
+
+func TestRefreshTaskStateRollsBackFailedDescribe(t *testing.T) {
+	running := task("running", "web", "a")
+	stopped := task("running", "web", "a")
+	stopped.LastStatus = aws.String(ecs.DesiredStatusStopped)
+
+	client := mocks.NewECSAPI(t)
+	client.On("ListTasksPages", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(1).(func(*ecs.ListTasksOutput, bool) bool)(&ecs.ListTasksOutput{TaskArns: []*string{running.TaskArn}}, true)
+	}).Return(nil).Once()
+	client.On("DescribeTasks", mock.Anything).Return(&ecs.DescribeTasksOutput{Tasks: []*ecs.Task{running}}, nil).Once()
+	client.On("ListTasksPages", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		fn := args.Get(1).(func(*ecs.ListTasksOutput, bool) bool)
+		if fn(&ecs.ListTasksOutput{TaskArns: []*string{stopped.TaskArn}}, false) {
+			fn(&ecs.ListTasksOutput{TaskArns: []*string{task("other", "web", "a").TaskArn}}, true)
+		}
+	}).Return(nil).Once()
+	client.On("DescribeTasks", mock.Anything).Return(&ecs.DescribeTasksOutput{Tasks: []*ecs.Task{stopped}}, nil).Once()
+	client.On("DescribeTasks", mock.Anything).Return(nil, errors.New("throttled")).Once()
+
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshTaskState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.RefreshTaskState(); err == nil {
+		t.Fatal("refresh succeeded despite a failed describe")
+	}
+
+	tasks := []ecs_state.Task{}
+	if err := state.DB().Find(&tasks).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].LastStatus != ecs.DesiredStatusRunning {
+		t.Errorf("kept %+v after the failed refresh, want the RUNNING Task from the first", tasks)
+	}
+}
+
+func TestRefreshContainerInstanceStateRollsBackFailedWrite(t *testing.T) {
+	failing := containerInstance("b", 1024, 1024)
+	failing.Tags = []*ecs.Tag{{Key: aws.String("fail"), Value: aws.String("write")}}
+
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 2048, 2048), failing)
+	state := newTestState(t, client, ecs_state.Options{
+		IncludeTags: true,
+		// Fails the write of the second instance's Tag, after the first instance has been written
+		AfterMigrate: func(db *gorm.DB) {
+			db.Exec("CREATE TRIGGER fail_tag BEFORE INSERT ON tags WHEN NEW.key = 'fail' BEGIN SELECT RAISE(ABORT, 'write failed'); END")
+		},
+	})
+	if err := state.RefreshContainerInstanceState(); err == nil {
+		t.Fatal("refresh succeeded despite a failed write")
+	}
+
+	count := 0
+	if err := state.DB().Model(&ecs_state.ContainerInstance{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("kept %d ContainerInstances from the failed refresh, want none", count)
+	}
+	if refreshed, err := state.LastRefresh(ecs_state.RefreshKindContainerInstances); err != nil || !refreshed.IsZero() {
+		t.Errorf("failed refresh recorded as succeeding at %v (%v)", refreshed, err)
+	}
+}
@@ -0,0 +1,103 @@
+package ecs_state
+
+import "github.com/jinzhu/gorm"
+
+// Removes a ContainerInstance, its Attributes, Tags, Tasks and reservations from local state immediately, such as after
+// an EC2 termination event.  Returns ErrContainerInstanceNotFound if the instance is not in local state.
+func (state *State) RemoveContainerInstance(arn string) error {
+	state.log.Info("entering RemoveContainerInstance()")
+	tx := state.DB().Begin()
+	containerInstance := ContainerInstance{}
+	if query := tx.Where("a_r_n = ?", arn).First(&containerInstance); query.Error != nil {
+		tx.Rollback()
+		if query.RecordNotFound() {
+			return ErrContainerInstanceNotFound
+		}
+		return query.Error
+	}
+
+	changes := []StateChange{}
+	tasks := []Task{}
+	if err := tx.Where("container_instance_a_r_n = ?", arn).Find(&tasks).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, task := range tasks {
+		if err := state.removeTask(tx, task); err != nil {
+			tx.Rollback()
+			return err
+		}
+		changes = append(changes, StateChange{Kind: RefreshKindTasks, Action: ChangeDelete, ARN: task.ARN})
+	}
+
+	for _, model := range []interface{}{Attribute{}, InstancePort{}} {
+		if err := tx.Where("container_instance_a_r_n = ?", arn).Delete(model).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Where("resource_a_r_n = ?", arn).Delete(Tag{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Delete(&containerInstance).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	changes = append(changes, StateChange{Kind: RefreshKindContainerInstances, Action: ChangeDelete, ARN: arn})
+	if err := state.commitRemoval(tx, changes); err != nil {
+		return err
+	}
+
+	state.reservationLock.Lock()
+	state.clearReservations(map[string]bool{arn: true})
+	state.reservationLock.Unlock()
+	return nil
+}
+
+// Removes a Task, its Tags, and its ContainerOverrides from local state immediately, leaving the remaining resources of
+// its ContainerInstance as ECS last reported them.  Returns ErrTaskNotFound if the Task is not in local state.
+func (state *State) RemoveTask(arn string) error {
+	state.log.Info("entering RemoveTask()")
+	tx := state.DB().Begin()
+	task := Task{}
+	if query := tx.Where("a_r_n = ?", arn).First(&task); query.Error != nil {
+		tx.Rollback()
+		if query.RecordNotFound() {
+			return ErrTaskNotFound
+		}
+		return query.Error
+	}
+
+	if err := state.removeTask(tx, task); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return state.commitRemoval(tx, []StateChange{{Kind: RefreshKindTasks, Action: ChangeDelete, ARN: arn}})
+}
+
+// Deletes a Task, its Tags, and its ContainerOverrides within a removal, recording it in TaskHistory first.
+func (state *State) removeTask(tx *gorm.DB, task Task) error {
+	if err := state.recordTaskHistory(tx, task, int(state.now().Unix())); err != nil {
+		return err
+	}
+	if err := tx.Where("resource_a_r_n = ?", task.ARN).Delete(Tag{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("task_a_r_n = ?", task.ARN).Delete(ContainerOverride{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&task).Error
+}
+
+// Commits the transaction of a removal and reports its changes to any OnChange hook.  Removals are explicit requests
+// rather than refreshes, so they are applied even in DryRun mode.
+func (state *State) commitRemoval(tx *gorm.DB, changes []StateChange) error {
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	if state.options.OnChange != nil {
+		state.options.OnChange(changes)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package ecs_state
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Identifies a reservation made with Reserve so that it can later be released.
+type ReservationID int
+
+// Resources deducted from a ContainerInstance in local state on behalf of a task that has not been seen by a refresh yet.
+type Reservation struct {
+	ID                   ReservationID
+	ContainerInstanceARN string
+	TaskDefinitionARN    string
+	Cpu                  int
+	Memory               int
+	TCPPorts             string
+	UDPPorts             string
+	DynamicTCPPorts      int
+	DynamicUDPPorts      int
+	// When a pending task registered with RegisterPendingTask is released automatically, or zero for never.
+	Expires time.Time
+}
+
+// A single task placement chosen from local state, naming the ContainerInstance to run the TaskDefinition on.
+// TCPPorts and UDPPorts are only set by SimulatePlacements, listing the host ports the task was allocated.
+type Placement struct {
+	ContainerInstanceARN string
+	TaskDefinitionARN    string
+	ReservationID        ReservationID
+	TCPPorts             []int
+	UDPPorts             []int
+}
+
+// Reserves a TaskDefinition on up to count ContainerInstances, packing onto the least free CPU first.  Returns the
+// placements that fit with ErrInsufficientCapacity if fewer than count do.
+func (state *State) ReservePlacements(td string, count int) ([]Placement, error) {
+	state.log.Info("entering ReservePlacements()")
+	taskDefinition, err := state.FindTaskDefinition(td)
+	if err != nil {
+		return []Placement{}, err
+	}
+
+	state.reservationLock.Lock()
+	defer state.reservationLock.Unlock()
+
+	state.expireReservations()
+	placements := []Placement{}
+	for len(placements) < count {
+		candidates := []ContainerInstance{}
+		if err := state.placementQuery(state.DB(), taskDefinition, PlacementOptions{Order: LeastFreeCPU}).Find(&candidates).Error; err != nil {
+			return placements, err
+		}
+		if len(candidates) == 0 {
+			state.log.Warn(fmt.Sprintf("Only able to place %d of %d tasks for %s", len(placements), count, td))
+			return placements, ErrInsufficientCapacity
+		}
+
+		containerInstance := candidates[0]
+		id, err := state.reserve(containerInstance, taskDefinition)
+		if err != nil {
+			return placements, err
+		}
+		placements = append(placements, Placement{ContainerInstanceARN: containerInstance.ARN, TaskDefinitionARN: taskDefinition.ARN, ReservationID: id})
+	}
+
+	return placements, nil
+}
+
+// Tentatively reserves a TaskDefinition's resources on a ContainerInstance until Release or the next refresh.
+func (state *State) Reserve(instanceARN string, td string) (ReservationID, error) {
+	state.log.Info("entering Reserve()")
+	taskDefinition, err := state.FindTaskDefinition(td)
+	if err != nil {
+		return 0, err
+	}
+
+	state.reservationLock.Lock()
+	defer state.reservationLock.Unlock()
+
+	state.expireReservations()
+	containerInstance := ContainerInstance{}
+	query := state.DB().Where("a_r_n = ?", instanceARN).First(&containerInstance)
+	if query.RecordNotFound() {
+		return 0, ErrContainerInstanceNotFound
+	} else if query.Error != nil {
+		return 0, query.Error
+	}
+	query = state.placementQuery(state.DB(), taskDefinition, PlacementOptions{}).Where("a_r_n = ?", instanceARN).First(&containerInstance)
+	if query.RecordNotFound() {
+		return 0, ErrInsufficientCapacity
+	} else if query.Error != nil {
+		return 0, query.Error
+	}
+
+	return state.reserve(containerInstance, taskDefinition)
+}
+
+// Returns the resources held by a reservation to its ContainerInstance.  Releasing a reservation that is unknown,
+// already released, or cleared by a refresh has no effect.
+func (state *State) Release(id ReservationID) {
+	state.log.Info("entering Release()")
+	state.reservationLock.Lock()
+	defer state.reservationLock.Unlock()
+
+	reservation, ok := state.reservations[id]
+	if !ok {
+		state.log.Debug("Reservation", id, "not found, nothing to release")
+		return
+	}
+	state.release(reservation)
+}
+
+// Deducts the resources of a task started on a ContainerInstance but not yet refreshed, until the next refresh,
+// ConfirmPendingTask, or Options.PendingTaskTTL.  Returns ErrContainerInstanceNotFound for an unknown instance.
+func (state *State) RegisterPendingTask(instanceARN string, td string) (ReservationID, error) {
+	state.log.Info("entering RegisterPendingTask()")
+	taskDefinition, err := state.FindTaskDefinition(td)
+	if err != nil {
+		return 0, err
+	}
+
+	state.reservationLock.Lock()
+	defer state.reservationLock.Unlock()
+
+	containerInstance := ContainerInstance{}
+	if state.DB().Where("a_r_n = ?", instanceARN).First(&containerInstance).RecordNotFound() {
+		return 0, ErrContainerInstanceNotFound
+	}
+	id, err := state.reserve(containerInstance, taskDefinition)
+	if err != nil {
+		return 0, err
+	}
+	if state.options.PendingTaskTTL > 0 {
+		reservation := state.reservations[id]
+		reservation.Expires = state.now().Add(state.options.PendingTaskTTL)
+		state.reservations[id] = reservation
+	}
+	return id, nil
+}
+
+// Forgets a pending task once RefreshTaskState has seen it, leaving its resources deducted until the next
+// RefreshContainerInstanceState.  Unknown, expired, or cleared pending tasks are ignored.
+func (state *State) ConfirmPendingTask(id ReservationID) {
+	state.log.Info("entering ConfirmPendingTask()")
+	state.reservationLock.Lock()
+	defer state.reservationLock.Unlock()
+
+	delete(state.reservations, id)
+}
+
+// Releases the resources of pending tasks whose PendingTaskTTL has passed, before a placement query.
+func (state *State) releaseExpiredPendingTasks() {
+	state.reservationLock.Lock()
+	defer state.reservationLock.Unlock()
+	state.expireReservations()
+}
+
+// Releases every reservation whose Expires has passed.  Callers must hold the reservationLock.
+func (state *State) expireReservations() {
+	now := state.now()
+	for _, reservation := range state.reservations {
+		if !reservation.Expires.IsZero() && now.After(reservation.Expires) {
+			state.log.Debug("Pending task", reservation.ID, "expired, releasing its resources")
+			state.release(reservation)
+		}
+	}
+}
+
+// Returns the resources held by a reservation to its ContainerInstance and forgets it.  Callers must hold the
+// reservationLock.
+func (state *State) release(reservation Reservation) {
+	delete(state.reservations, reservation.ID)
+
+	containerInstance := ContainerInstance{}
+	if state.DB().Where("a_r_n = ?", reservation.ContainerInstanceARN).First(&containerInstance).RecordNotFound() {
+		return
+	}
+	updated := containerInstance
+	updated.RemainingCPU += reservation.Cpu
+	updated.RemainingMemory += reservation.Memory
+	updated.RemainingTCPPorts = removePorts(containerInstance.RemainingTCPPorts, reservation.TCPPorts)
+	updated.RemainingUDPPorts = removePorts(containerInstance.RemainingUDPPorts, reservation.UDPPorts)
+	updated.FreeDynamicTCPPorts += reservation.DynamicTCPPorts + state.fixedDynamicPorts(reservation.TCPPorts)
+	updated.FreeDynamicUDPPorts += reservation.DynamicUDPPorts + state.fixedDynamicPorts(reservation.UDPPorts)
+	state.updateRemaining(containerInstance, updated)
+}
+
+// Deducts the resources a TaskDefinition requires from a ContainerInstance in local state and records the reservation.
+// Callers must hold the reservationLock.
+func (state *State) reserve(containerInstance ContainerInstance, taskDefinition TaskDefinition) (ReservationID, error) {
+	updated := containerInstance
+	updated.RemainingCPU -= taskDefinition.Cpu
+	updated.RemainingMemory -= taskDefinition.Memory
+	updated.RemainingTCPPorts += encodePortList(taskDefinition.TCPPorts)
+	updated.RemainingUDPPorts += encodePortList(taskDefinition.UDPPorts)
+	// Fixed ports within the dynamic port range can no longer be bound dynamically either
+	updated.FreeDynamicTCPPorts -= taskDefinition.DynamicTCPPorts + state.fixedDynamicPorts(taskDefinition.TCPPorts)
+	updated.FreeDynamicUDPPorts -= taskDefinition.DynamicUDPPorts + state.fixedDynamicPorts(taskDefinition.UDPPorts)
+	if err := state.updateRemaining(containerInstance, updated); err != nil {
+		return 0, err
+	}
+
+	state.lastReservationID++
+	reservation := Reservation{
+		ID:                   state.lastReservationID,
+		ContainerInstanceARN: containerInstance.ARN,
+		TaskDefinitionARN:    taskDefinition.ARN,
+		Cpu:                  taskDefinition.Cpu,
+		Memory:               taskDefinition.Memory,
+		TCPPorts:             taskDefinition.TCPPorts,
+		UDPPorts:             taskDefinition.UDPPorts,
+		DynamicTCPPorts:      taskDefinition.DynamicTCPPorts,
+		DynamicUDPPorts:      taskDefinition.DynamicUDPPorts,
+	}
+	if state.reservations == nil {
+		state.reservations = map[ReservationID]Reservation{}
+	}
+	state.reservations[reservation.ID] = reservation
+	state.log.Debug(fmt.Sprintf("Reserved: %+v", reservation))
+	return reservation.ID, nil
+}
+
+// Writes the remaining resources of updated over those of containerInstance, keeping any normalized ports in step.
+func (state *State) updateRemaining(containerInstance ContainerInstance, updated ContainerInstance) error {
+	err := state.DB().Model(&containerInstance).UpdateColumns(map[string]interface{}{
+		"remaining_cpu":          updated.RemainingCPU,
+		"remaining_memory":       updated.RemainingMemory,
+		"remaining_tcp_ports":    updated.RemainingTCPPorts,
+		"remaining_udp_ports":    updated.RemainingUDPPorts,
+		"free_dynamic_tcp_ports": updated.FreeDynamicTCPPorts,
+		"free_dynamic_udp_ports": updated.FreeDynamicUDPPorts,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return state.storeInstancePorts(state.DB(), updated)
+}
+
+// Forgets every outstanding reservation on the given ContainerInstances, called once a refresh has replaced their
+// remaining resources with the authoritative values from ECS.  Callers must hold the reservationLock.
+func (state *State) clearReservations(instanceARNs map[string]bool) {
+	for id, reservation := range state.reservations {
+		if instanceARNs[reservation.ContainerInstanceARN] {
+			delete(state.reservations, id)
+		}
+	}
+}
+
+// Serializes the comma separated ports of a TaskDefinition in the searchable format of the ContainerInstance port columns.
+func encodePortList(ports string) string {
+	encoded := ""
+	for _, port := range strings.Split(ports, ",") {
+		if len(port) != 0 {
+			encoded += fmt.Sprintf("=%s=", port)
+		}
+	}
+
+	return encoded
+}
+
+// Removes a single occurrence of each comma separated port from a string in the searchable port format.
+func removePorts(encoded string, ports string) string {
+	for _, port := range strings.Split(ports, ",") {
+		if len(port) != 0 {
+			encoded = strings.Replace(encoded, fmt.Sprintf("=%s=", port), "", 1)
+		}
+	}
+
+	return encoded
+}
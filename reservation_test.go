@@ -0,0 +1,96 @@
+package ecs_state_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+)
+
+func TestReserveOnceContainerInstanceRefreshApplied(t *testing.T) {
+	const instanceARN = "arn:aws:ecs:us-east-1:123456789012:container-instance/test/a"
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 2048, 2048))
+	expectTaskDefinitions(client, taskDefinition("web", 256, 256))
+
+	var state *ecs_state.State
+	var id ecs_state.ReservationID
+	var reserveErr error
+	// Reserves as soon as the refresh reports its changes, which must not be cleared as superseded by that refresh
+	state = newTestState(t, client, ecs_state.Options{OnChange: func([]ecs_state.StateChange) {
+		id, reserveErr = state.Reserve(instanceARN, "web:1")
+	}})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+	if reserveErr != nil {
+		t.Fatal(reserveErr)
+	}
+	if cpu := remainingCPU(t, state, instanceARN); cpu != 2048-256 {
+		t.Errorf("remaining CPU is %d with the reservation, want %d", cpu, 2048-256)
+	}
+	state.Release(id)
+	if cpu := remainingCPU(t, state, instanceARN); cpu != 2048 {
+		t.Errorf("remaining CPU is %d once released, want the 2048 ECS reported", cpu)
+	}
+}
+
+// The RemainingCPU of a ContainerInstance in local state.
+func remainingCPU(t *testing.T, state *ecs_state.State, instanceARN string) int {
+	containerInstance := ecs_state.ContainerInstance{}
+	if err := state.DB().Where("a_r_n = ?", instanceARN).First(&containerInstance).Error; err != nil {
+		t.Fatal(err)
+	}
+	return containerInstance.RemainingCPU
+}
+
+func TestReserveErrors(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 2048, 2048), containerInstance("full", 128, 128))
+	expectTaskDefinitions(client, taskDefinition("web", 256, 256))
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+
+	for instanceARN, want := range map[string]error{
+		"arn:aws:ecs:us-east-1:123456789012:container-instance/test/unknown": ecs_state.ErrContainerInstanceNotFound,
+		"arn:aws:ecs:us-east-1:123456789012:container-instance/test/full":    ecs_state.ErrInsufficientCapacity,
+		"arn:aws:ecs:us-east-1:123456789012:container-instance/test/a":       nil,
+	} {
+		if _, err := state.Reserve(instanceARN, "web:1"); err != want {
+			t.Errorf("Reserve(%s) returned %v, want %v", instanceARN, err, want)
+		}
+	}
+}
+
+func TestReservePlacementsPacksLeastFreeCPU(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 2048, 2048), containerInstance("b", 512, 2048), containerInstance("c", 1024, 2048))
+	expectTaskDefinitions(client, taskDefinition("web", 256, 256))
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+
+	placements, err := state.ReservePlacements("web:1", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	placed := []string{}
+	for _, placement := range placements {
+		placed = append(placed, placement.ContainerInstanceARN[len(placement.ContainerInstanceARN)-1:])
+	}
+	// b fills up first, then c is the next fullest
+	if strings.Join(placed, "") != "bbcc" {
+		t.Errorf("placed on %v, want b twice then c twice", placed)
+	}
+
+	if err := state.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.ReservePlacements("web:1", 1); err == nil || err == ecs_state.ErrInsufficientCapacity {
+		t.Errorf("returned %v after Close, want the database error", err)
+	}
+}
@@ -0,0 +1,26 @@
+package ecs_state
+
+// Local representation of an ECS service and stored by gorm.  Tasks started by a service carry the Group
+// "service:<Name>", which FindTasksByService uses to link them.  TaskSets are only present for services using
+// blue/green or external deployments.
+type Service struct {
+	ARN               string `sql:"size:1024" gorm:"primary_key"`
+	Name              string `sql:"index"`
+	ClusterARN        string `sql:"size:1024;index"`
+	Status            string
+	TaskDefinitionARN string `sql:"size:1024"`
+	LaunchType        string
+	DesiredCount      int
+	RunningCount      int
+	PendingCount      int
+	Deployments       []Deployment
+	TaskSets          []TaskSet
+
+	// Not part of the ECS API
+	RefreshTime int
+}
+
+// The table Services are stored in, before any Options.TablePrefix.
+func (Service) TableName() string {
+	return "services"
+}
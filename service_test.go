@@ -0,0 +1,76 @@
+package ecs_state_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+)
+
+func TestRefreshServiceStateKeepsDeploymentCreatedAt(t *testing.T) {
+	created := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	client := mocks.NewECSAPI(t)
+	expectServices(client, &ecs.Service{
+		ServiceArn:     aws.String("arn:aws:ecs:us-east-1:123456789012:service/test/web"),
+		ServiceName:    aws.String("web"),
+		ClusterArn:     aws.String(testClusterARN),
+		TaskDefinition: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/web:2"),
+		DesiredCount:   aws.Int64(2),
+		Deployments: []*ecs.Deployment{{
+			Id:             aws.String("ecs-svc/1"),
+			Status:         aws.String("PRIMARY"),
+			TaskDefinition: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/web:2"),
+			CreatedAt:      aws.Time(created),
+		}},
+	})
+	state := newTestState(t, client, ecs_state.Options{})
+
+	if err := state.RefreshServiceState(); err != nil {
+		t.Fatal(err)
+	}
+	service, err := state.FindServiceByName("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(service.Deployments) != 1 {
+		t.Fatalf("expected 1 deployment, found %d", len(service.Deployments))
+	}
+	if got := service.Deployments[0].CreatedAtUnix; got != int(created.Unix()) {
+		t.Errorf("deployment created at %d, want %d", got, created.Unix())
+	}
+}
+
+func TestRefreshServiceStateKeepsTaskSetCreatedAt(t *testing.T) {
+	created := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	client := mocks.NewECSAPI(t)
+	expectServices(client, &ecs.Service{
+		ServiceArn:  aws.String("arn:aws:ecs:us-east-1:123456789012:service/test/web"),
+		ServiceName: aws.String("web"),
+		ClusterArn:  aws.String(testClusterARN),
+		TaskSets: []*ecs.TaskSet{{
+			Id:             aws.String("ecs-svc/2"),
+			TaskSetArn:     aws.String("arn:aws:ecs:us-east-1:123456789012:task-set/test/web/ecs-svc/2"),
+			Status:         aws.String("ACTIVE"),
+			TaskDefinition: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/web:3"),
+			CreatedAt:      aws.Time(created),
+		}},
+	})
+	state := newTestState(t, client, ecs_state.Options{})
+
+	if err := state.RefreshServiceState(); err != nil {
+		t.Fatal(err)
+	}
+	service, err := state.FindServiceByName("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(service.TaskSets) != 1 {
+		t.Fatalf("expected 1 task set, found %d", len(service.TaskSets))
+	}
+	if got := service.TaskSets[0].CreatedAtUnix; got != int(created.Unix()) {
+		t.Errorf("task set created at %d, want %d", got, created.Unix())
+	}
+}
@@ -0,0 +1,225 @@
+package ecs_state
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// The outcome of SimulatePlacements, listing the instance chosen for every task that fit and, per requested
+// TaskDefinition, how many tasks could not be placed.
+type PlacementResult struct {
+	Placements []Placement
+	Unplaced   map[string]int
+}
+
+// Whether every requested task was placed.
+func (result PlacementResult) Complete() bool {
+	return len(result.Unplaced) == 0
+}
+
+// The capacity of a ContainerInstance as it is used up during a simulation.
+type simulatedInstance struct {
+	arn      string
+	cpu      int
+	memory   int
+	tcpPorts simulatedPorts
+	udpPorts simulatedPorts
+}
+
+// The host ports of one protocol on a simulated ContainerInstance, tracking the specific ports claimed so that two
+// simulated tasks never share one, and how many ports of the dynamic port range remain.
+type simulatedPorts struct {
+	used        map[int]bool
+	portRange   PortRange
+	dynamicFree int
+	// The lowest port of the dynamic range that may still be free
+	nextDynamic int
+}
+
+// Answers whether the requested number of tasks of each TaskDefinition could be placed now, assigning them greedily
+// to the least free CPU as ECS binpack would.  Nothing is reserved.
+func (state *State) SimulatePlacements(requests map[string]int) (PlacementResult, error) {
+	state.log.Info("entering SimulatePlacements()")
+	result := PlacementResult{Unplaced: map[string]int{}}
+
+	tds := []string{}
+	for td := range requests {
+		tds = append(tds, td)
+	}
+	taskDefinitions, err := state.FindTaskDefinitions(tds)
+	if err != nil {
+		return result, err
+	}
+	sort.Slice(tds, func(i, j int) bool {
+		a, b := taskDefinitions[tds[i]], taskDefinitions[tds[j]]
+		if a.Cpu != b.Cpu {
+			return a.Cpu > b.Cpu
+		}
+		if a.Memory != b.Memory {
+			return a.Memory > b.Memory
+		}
+		return tds[i] < tds[j]
+	})
+
+	// Read under the reservationLock so that reservations made concurrently are either fully reflected or not at all
+	containerInstances := []ContainerInstance{}
+	state.reservationLock.Lock()
+	state.expireReservations()
+	err = state.DB().Where("agent_connected = ? AND status <> ?", true, ecs.ContainerInstanceStatusDraining).Order("a_r_n").
+		Preload("Tasks", "last_status <> ?", ecs.DesiredStatusStopped).Find(&containerInstances).Error
+	state.reservationLock.Unlock()
+	if err != nil {
+		return result, err
+	}
+	instances := []*simulatedInstance{}
+	for _, containerInstance := range containerInstances {
+		instances = append(instances, state.newSimulatedInstance(containerInstance))
+	}
+
+	for _, td := range tds {
+		taskDefinition := taskDefinitions[td]
+		tcpPorts := splitPortList(taskDefinition.TCPPorts)
+		udpPorts := splitPortList(taskDefinition.UDPPorts)
+		for placed := 0; placed < requests[td]; placed++ {
+			instance := bestSimulatedInstance(instances, taskDefinition, tcpPorts, udpPorts)
+			if instance == nil {
+				result.Unplaced[td] = requests[td] - placed
+				break
+			}
+			placement := instance.place(taskDefinition, tcpPorts, udpPorts)
+			result.Placements = append(result.Placements, placement)
+		}
+	}
+
+	state.log.Debug(fmt.Sprintf("Simulated %d placements, unplaced: %v", len(result.Placements), result.Unplaced))
+	return result, nil
+}
+
+// Copies the remaining resources of a ContainerInstance for use in a simulation, with its Tasks loaded so that the
+// ports they are bound to are never claimed.
+func (state *State) newSimulatedInstance(containerInstance ContainerInstance) *simulatedInstance {
+	portRange := state.dynamicPortRange()
+	tcpPorts, udpPorts := []string{containerInstance.RemainingTCPPorts}, []string{containerInstance.RemainingUDPPorts}
+	for _, task := range containerInstance.Tasks {
+		tcpPorts = append(tcpPorts, task.TCPPorts)
+		udpPorts = append(udpPorts, task.UDPPorts)
+	}
+	return &simulatedInstance{
+		arn:      containerInstance.ARN,
+		cpu:      containerInstance.RemainingCPU,
+		memory:   containerInstance.RemainingMemory,
+		tcpPorts: newSimulatedPorts(portRange, containerInstance.FreeDynamicTCPPorts, tcpPorts...),
+		udpPorts: newSimulatedPorts(portRange, containerInstance.FreeDynamicUDPPorts, udpPorts...),
+	}
+}
+
+// Whether the instance has the resources and free ports left for the TaskDefinition.
+func (instance *simulatedInstance) fits(taskDefinition TaskDefinition, tcpPorts, udpPorts []int) bool {
+	if instance.cpu < taskDefinition.Cpu || instance.memory < taskDefinition.Memory {
+		return false
+	}
+	return instance.tcpPorts.fit(tcpPorts, taskDefinition.DynamicTCPPorts) &&
+		instance.udpPorts.fit(udpPorts, taskDefinition.DynamicUDPPorts)
+}
+
+// Uses up the resources and ports of the TaskDefinition on the instance, returning the resulting Placement.
+func (instance *simulatedInstance) place(taskDefinition TaskDefinition, tcpPorts, udpPorts []int) Placement {
+	instance.cpu -= taskDefinition.Cpu
+	instance.memory -= taskDefinition.Memory
+	return Placement{
+		ContainerInstanceARN: instance.arn,
+		TaskDefinitionARN:    taskDefinition.ARN,
+		TCPPorts:             instance.tcpPorts.claim(tcpPorts, taskDefinition.DynamicTCPPorts),
+		UDPPorts:             instance.udpPorts.claim(udpPorts, taskDefinition.DynamicUDPPorts),
+	}
+}
+
+// Tracks the ports in use from their searchable format, along with the count of free dynamic ports, which also
+// accounts for reservations of dynamic ports that were never assigned a specific port.
+func newSimulatedPorts(portRange PortRange, dynamicFree int, encoded ...string) simulatedPorts {
+	ports := simulatedPorts{used: map[int]bool{}, portRange: portRange, dynamicFree: dynamicFree, nextDynamic: portRange.Low}
+	for _, inUse := range encoded {
+		for _, port := range ParsePorts(inUse) {
+			ports.used[port] = true
+		}
+	}
+	return ports
+}
+
+// Whether none of the fixed ports are in use and enough of the dynamic range remains for them and dynamic more.
+func (ports *simulatedPorts) fit(fixed []int, dynamic int) bool {
+	needed := dynamic
+	for _, port := range fixed {
+		if ports.used[port] {
+			return false
+		}
+		if ports.inDynamicRange(port) {
+			needed++
+		}
+	}
+	return ports.dynamicFree >= needed
+}
+
+// Claims the fixed ports and allocates dynamic more from the dynamic range, returning every port claimed.
+func (ports *simulatedPorts) claim(fixed []int, dynamic int) []int {
+	claimed := []int{}
+	for _, port := range fixed {
+		ports.use(port)
+		claimed = append(claimed, port)
+	}
+	for ; dynamic > 0; dynamic-- {
+		for ports.nextDynamic <= ports.portRange.High && ports.used[ports.nextDynamic] {
+			ports.nextDynamic++
+		}
+		// The free count and the ports known to be bound can disagree until the next refresh, so the range may run out first
+		if ports.nextDynamic > ports.portRange.High {
+			ports.dynamicFree -= dynamic
+			break
+		}
+		claimed = append(claimed, ports.nextDynamic)
+		ports.use(ports.nextDynamic)
+	}
+	return claimed
+}
+
+// Marks a port as in use.
+func (ports *simulatedPorts) use(port int) {
+	ports.used[port] = true
+	if ports.inDynamicRange(port) {
+		ports.dynamicFree--
+	}
+}
+
+// Whether the port is part of the dynamic port range.
+func (ports *simulatedPorts) inDynamicRange(port int) bool {
+	return port >= ports.portRange.Low && port <= ports.portRange.High
+}
+
+// Chooses the instance with the least remaining CPU, then memory, that still fits the TaskDefinition, or nil if none do.
+func bestSimulatedInstance(instances []*simulatedInstance, taskDefinition TaskDefinition, tcpPorts, udpPorts []int) *simulatedInstance {
+	var best *simulatedInstance
+	for _, instance := range instances {
+		if !instance.fits(taskDefinition, tcpPorts, udpPorts) {
+			continue
+		}
+		if best == nil || instance.cpu < best.cpu || (instance.cpu == best.cpu && instance.memory < best.memory) {
+			best = instance
+		}
+	}
+	return best
+}
+
+// Parses the comma separated ports of a TaskDefinition into port numbers.
+func splitPortList(ports string) []int {
+	parsed := []int{}
+	for _, port := range strings.Split(ports, ",") {
+		if value, err := strconv.Atoi(port); err == nil {
+			parsed = append(parsed, value)
+		}
+	}
+	return parsed
+}
@@ -0,0 +1,74 @@
+package ecs_state_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+)
+
+func TestSimulatePlacementsFixedPortOnce(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 4096, 4096, "8080"), containerInstance("b", 4096, 4096))
+	expectTaskDefinitions(client, taskDefinition("web", 256, 256, portMapping(8080, "tcp")))
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := state.SimulatePlacements(map[string]int{"web:1": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Placements) != 1 || result.Placements[0].ContainerInstanceARN != "arn:aws:ecs:us-east-1:123456789012:container-instance/test/b" {
+		t.Fatalf("placed %+v, want a single task on the instance with 8080 free", result.Placements)
+	}
+	if result.Unplaced["web:1"] != 1 {
+		t.Errorf("left %d tasks unplaced, want 1", result.Unplaced["web:1"])
+	}
+}
+
+func TestSimulatePlacementsDynamicPortsStayInRange(t *testing.T) {
+	running := task("web", "web", "a")
+	running.Containers = []*ecs.Container{{NetworkBindings: []*ecs.NetworkBinding{
+		{HostPort: aws.Int64(50000), Protocol: aws.String(ecs.TransportProtocolTcp)},
+	}}}
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 4096, 4096))
+	expectTasks(client, running)
+	expectTaskDefinitions(client, taskDefinition("web", 256, 256, portMapping(0, "tcp")))
+	state := newTestState(t, client, ecs_state.Options{DynamicPortRange: ecs_state.PortRange{Low: 50000, High: 50002}})
+	if err := state.RefreshAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := state.SimulatePlacements(map[string]int{"web:1": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Placements) != 2 || result.Unplaced["web:1"] != 1 {
+		t.Fatalf("placed %+v, want the two tasks the free dynamic ports allow", result.Placements)
+	}
+	if result.Placements[0].TCPPorts[0] != 50001 || result.Placements[1].TCPPorts[0] != 50002 {
+		t.Errorf("claimed %v and %v, want 50001 and 50002 around the bound 50000",
+			result.Placements[0].TCPPorts, result.Placements[1].TCPPorts)
+	}
+
+	// A free count overstating the range, as when local state lags, never claims ports beyond it
+	if err := state.DB().Model(&ecs_state.ContainerInstance{}).UpdateColumn("free_dynamic_tcp_ports", 5).Error; err != nil {
+		t.Fatal(err)
+	}
+	result, err = state.SimulatePlacements(map[string]int{"web:1": 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, placement := range result.Placements {
+		for _, port := range placement.TCPPorts {
+			if port < 50000 || port > 50002 {
+				t.Errorf("claimed port %d outside the dynamic port range", port)
+			}
+		}
+	}
+}
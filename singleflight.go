@@ -0,0 +1,55 @@
+package ecs_state
+
+import (
+	"errors"
+	"sync"
+)
+
+// A call in progress for a flightGroup, shared by every caller of the same key.
+type flightCall struct {
+	done sync.WaitGroup
+	err  error
+}
+
+// Coalesces concurrent calls with the same key into a single call whose result they all share, as
+// golang.org/x/sync/singleflight does, so that concurrent callers make one round of ECS requests between them.
+// The zero value is ready to use.
+type flightGroup struct {
+	lock  sync.Mutex
+	calls map[string]*flightCall
+}
+
+// Returned to the callers waiting on a call whose fn panicked, the panic itself propagating to the caller running it.
+var errFlightPanicked = errors.New("ecs_state: coalesced call panicked")
+
+// Calls fn unless a call for key is already in progress, in which case that call's result is waited for instead.
+func (group *flightGroup) do(key string, fn func() error) error {
+	group.lock.Lock()
+	if group.calls == nil {
+		group.calls = map[string]*flightCall{}
+	}
+	if call, ok := group.calls[key]; ok {
+		group.lock.Unlock()
+		call.done.Wait()
+		return call.err
+	}
+	call := &flightCall{}
+	call.done.Add(1)
+	group.calls[key] = call
+	group.lock.Unlock()
+
+	// Deferred so that a panic in fn still releases the waiters and lets the next call for key run.
+	returned := false
+	defer func() {
+		if !returned {
+			call.err = errFlightPanicked
+		}
+		group.lock.Lock()
+		delete(group.calls, key)
+		group.lock.Unlock()
+		call.done.Done()
+	}()
+	call.err = fn()
+	returned = true
+	return call.err
+}
@@ -0,0 +1,23 @@
+package ecs_state
+
+import "testing"
+
+func TestFlightGroupRecoversFromPanic(t *testing.T) {
+	group := flightGroup{}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected the panic to reach the caller running fn")
+			}
+		}()
+		group.do("tasks", func() error { panic("boom") })
+	}()
+
+	if len(group.calls) != 0 {
+		t.Fatalf("expected no calls in progress after a panic, found %d", len(group.calls))
+	}
+	ran := false
+	if err := group.do("tasks", func() error { ran = true; return nil }); err != nil || !ran {
+		t.Errorf("call after a panic returned %v and ran %v, want nil and true", err, ran)
+	}
+}
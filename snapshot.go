@@ -0,0 +1,198 @@
+package ecs_state
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// A serializable copy of every row in the local state.  Snapshots can be attached to bug reports
+// so that placement decisions can be reproduced offline without access to AWS.
+type Snapshot struct {
+	Clusters                      []Cluster
+	CapacityProviderStrategyItems []CapacityProviderStrategyItem
+	ContainerInstances            []ContainerInstance
+	Attributes                    []Attribute
+	Tags                          []Tag
+	Tasks                         []Task
+	ContainerOverrides            []ContainerOverride
+	TaskDefinitions               []TaskDefinition
+	ContainerDefinitions          []ContainerDefinition
+	TaskDefinitionAliases         []TaskDefinitionAlias
+	Services                      []Service
+	Deployments                   []Deployment
+	TaskSets                      []TaskSet
+	TaskHistory                   []TaskHistory
+}
+
+// Writes every row in the local state, from Clusters through to Services and recorded TaskHistory, to w as JSON.
+func (state *State) ExportSnapshot(w io.Writer) error {
+	state.log.Info("entering ExportSnapshot()")
+	snapshot := Snapshot{}
+	if err := state.DB().Find(&snapshot.Clusters).Error; err != nil {
+		return err
+	}
+	if err := state.DB().Find(&snapshot.CapacityProviderStrategyItems).Error; err != nil {
+		return err
+	}
+	if err := state.DB().Find(&snapshot.ContainerInstances).Error; err != nil {
+		return err
+	}
+	if err := state.DB().Find(&snapshot.Attributes).Error; err != nil {
+		return err
+	}
+	if err := state.DB().Find(&snapshot.Tags).Error; err != nil {
+		return err
+	}
+	if err := state.DB().Find(&snapshot.Tasks).Error; err != nil {
+		return err
+	}
+	if err := state.DB().Find(&snapshot.ContainerOverrides).Error; err != nil {
+		return err
+	}
+	if err := state.DB().Find(&snapshot.TaskDefinitions).Error; err != nil {
+		return err
+	}
+	if err := state.DB().Find(&snapshot.ContainerDefinitions).Error; err != nil {
+		return err
+	}
+	if err := state.DB().Find(&snapshot.TaskDefinitionAliases).Error; err != nil {
+		return err
+	}
+	if err := state.DB().Find(&snapshot.Services).Error; err != nil {
+		return err
+	}
+	if err := state.DB().Find(&snapshot.Deployments).Error; err != nil {
+		return err
+	}
+	if err := state.DB().Find(&snapshot.TaskSets).Error; err != nil {
+		return err
+	}
+	if err := state.DB().Find(&snapshot.TaskHistory).Error; err != nil {
+		return err
+	}
+
+	state.log.Debug("Exporting snapshot with", len(snapshot.Clusters), "clusters,", len(snapshot.ContainerInstances),
+		"container instances,", len(snapshot.Tasks), "tasks, and", len(snapshot.TaskDefinitions), "task definitions")
+	return json.NewEncoder(w).Encode(snapshot)
+}
+
+// Replaces the local state with a snapshot previously written by ExportSnapshot.  All existing rows are
+// removed first, and nothing is changed if the snapshot cannot be decoded or loaded.
+func (state *State) ImportSnapshot(r io.Reader) error {
+	state.log.Info("entering ImportSnapshot()")
+	snapshot := Snapshot{}
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	tx := state.DB().Begin()
+	for _, model := range []interface{}{&Task{}, &ContainerOverride{}, &Tag{}, &Attribute{}, &ContainerInstance{}, &CapacityProviderStrategyItem{}, &Cluster{}, &ContainerDefinition{}, &TaskDefinition{}, &TaskDefinitionAlias{}, &Deployment{}, &TaskSet{}, &Service{}, &InstancePort{}, &TaskHistory{}} {
+		if err := tx.Delete(model).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for _, cluster := range snapshot.Clusters {
+		// Associations are stored in their own slices of the snapshot
+		cluster.DefaultCapacityProviderStrategy = nil
+		cluster.ContainerInstances = nil
+		cluster.Tasks = nil
+		if err := tx.Create(&cluster).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, item := range snapshot.CapacityProviderStrategyItems {
+		if err := tx.Create(&item).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, containerInstance := range snapshot.ContainerInstances {
+		containerInstance.Tasks = nil
+		if err := tx.Create(&containerInstance).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		// Normalized ports are derived from the port columns rather than stored in snapshots.
+		if err := state.storeInstancePorts(tx, containerInstance); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, attribute := range snapshot.Attributes {
+		if err := tx.Create(&attribute).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, tag := range snapshot.Tags {
+		if err := tx.Create(&tag).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, task := range snapshot.Tasks {
+		task.ContainerOverrides = nil
+		if err := tx.Create(&task).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, containerOverride := range snapshot.ContainerOverrides {
+		if err := tx.Create(&containerOverride).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, taskDefinition := range snapshot.TaskDefinitions {
+		taskDefinition.ContainerDefinitions = nil
+		if err := tx.Create(&taskDefinition).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, containerDefinition := range snapshot.ContainerDefinitions {
+		if err := tx.Create(&containerDefinition).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, alias := range snapshot.TaskDefinitionAliases {
+		if err := tx.Create(&alias).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, service := range snapshot.Services {
+		service.Deployments = nil
+		service.TaskSets = nil
+		if err := tx.Create(&service).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, deployment := range snapshot.Deployments {
+		if err := tx.Create(&deployment).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, taskSet := range snapshot.TaskSets {
+		if err := tx.Create(&taskSet).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, history := range snapshot.TaskHistory {
+		if err := tx.Create(&history).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	state.log.Debug("Imported snapshot with", len(snapshot.Clusters), "clusters,", len(snapshot.ContainerInstances),
+		"container instances,", len(snapshot.Tasks), "tasks, and", len(snapshot.TaskDefinitions), "task definitions")
+	return tx.Commit().Error
+}
@@ -0,0 +1,98 @@
+package ecs_state_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestImportSnapshotReplacesAliases(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	// Families resolve to their only revision
+	client.On("DescribeTaskDefinition", mock.Anything).Return(func(input *ecs.DescribeTaskDefinitionInput) *ecs.DescribeTaskDefinitionOutput {
+		return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: taskDefinition(aws.StringValue(input.TaskDefinition), 256, 256)}
+	}, nil)
+	exported := newTestState(t, client, ecs_state.Options{})
+	if _, err := exported.FindTaskDefinition("web"); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := bytes.Buffer{}
+	if err := exported.ExportSnapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	imported := newTestState(t, client, ecs_state.Options{})
+	if _, err := imported.FindTaskDefinition("old"); err != nil {
+		t.Fatal(err)
+	}
+	// No further DescribeTaskDefinition calls are expected, as the family resolves through the imported alias
+	offline := mocks.NewECSAPI(t)
+	replay := newTestState(t, offline, ecs_state.Options{})
+	if err := replay.ImportSnapshot(bytes.NewReader(snapshot.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	taskDefinition, err := replay.FindTaskDefinition("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taskDefinition.ShortString != "web:1" {
+		t.Errorf("resolved web to %s, want web:1", taskDefinition.ShortString)
+	}
+
+	if err := imported.ImportSnapshot(bytes.NewReader(snapshot.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	aliases := []ecs_state.TaskDefinitionAlias{}
+	if err := imported.DB().Find(&aliases).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 1 || aliases[0].Family != "web" {
+		t.Errorf("kept aliases %+v after import, want only web", aliases)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 2048, 2048, "8080"), withUDPPorts(containerInstance("b", 512, 512), "53"))
+	expectTasks(client, task("web", "web", "a"))
+	expectTaskDefinitions(client, taskDefinition("web", 256, 256, portMapping(8080, "tcp")), taskDefinition("dns", 256, 256, portMapping(53, "udp")))
+	exported := newTestState(t, client, ecs_state.Options{})
+	if err := exported.RefreshAll(); err != nil {
+		t.Fatal(err)
+	}
+	for _, td := range []string{"web:1", "dns:1"} {
+		if _, err := exported.FindTaskDefinition(td); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := bytes.Buffer{}
+	if err := exported.ExportSnapshot(&first); err != nil {
+		t.Fatal(err)
+	}
+
+	// The importing state never calls ECS, so placement is answered from the snapshot alone
+	imported := newTestState(t, mocks.NewECSAPI(t), ecs_state.Options{})
+	if err := imported.ImportSnapshot(bytes.NewReader(first.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	second := bytes.Buffer{}
+	if err := imported.ExportSnapshot(&second); err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != second.String() {
+		t.Errorf("exported\n%s\nafter importing\n%s", second.String(), first.String())
+	}
+
+	// a already binds TCP 8080 and b UDP 53, so each definition only fits on the other instance
+	for td, want := range map[string]string{"web:1": "b", "dns:1": "a"} {
+		arns := instanceARNs(*imported.FindLocationsForTaskDefinition(td))
+		if len(arns) != 1 || arns[0] != "arn:aws:ecs:us-east-1:123456789012:container-instance/test/"+want {
+			t.Errorf("placed %s on %v after import, want only %s", td, arns, want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package ecs_state
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+//go:generate mockery --name StateOps --output mocks --outpkg mocks
+
+// The operations of a State used by schedulers, so that they can be written against an interface and tested with the
+// generated mock in the mocks package instead of a State backed by ECS.  It is satisfied by *State, and covers the
+// refreshes and every query of the local state.  Methods that reserve resources, change the local state outside of a
+// refresh, or expose the underlying database are left to State.  After adding a query to State, add it here and
+// regenerate the mock with go generate.
+type StateOps interface {
+	// Refreshing the local state from ECS
+	RefreshClusterState() error
+	RefreshContainerInstanceState() error
+	RefreshContainerInstanceStateWithContext(ctx context.Context, progress chan<- RefreshProgress) error
+	RefreshTaskState() error
+	RefreshTaskStateWithStatus(desiredStatus string) error
+	RefreshTaskStateWithContext(ctx context.Context, progress chan<- RefreshProgress) error
+	RefreshServiceState() error
+	RefreshAll() error
+	RefreshTaskDefinition(td string) (TaskDefinition, error)
+	LastRefresh(kind string) (time.Time, error)
+	Healthy() error
+	Validate() ([]Inconsistency, error)
+
+	// Clusters and Services
+	FindClusterByName(name string) Cluster
+	FindClusterByNameE(name string) (Cluster, error)
+	FindServiceByName(name string) (Service, error)
+	DeploymentProgress(serviceName string) (DeploymentStatus, error)
+
+	// ContainerInstances
+	ListContainerInstances(options ...ListOptions) ([]ContainerInstance, error)
+	ListContainerInstanceARNs() ([]string, error)
+	FindInstanceByEC2Id(id string) (ContainerInstance, error)
+	FindInstancesByType(instanceType string) ([]ContainerInstance, error)
+	FindInstancesWithAgentOlderThan(version string) ([]ContainerInstance, error)
+	FindInstancesWithCapacity(cpu int, memory int) ([]ContainerInstance, error)
+	FindInstancesBelowMemoryPercent(pct float64) ([]ContainerInstance, error)
+	FindIdleInstances() ([]ContainerInstance, error)
+	FindUnhealthyInstances() ([]ContainerInstance, error)
+	FindDisconnectedInstances(olderThan time.Duration) ([]ContainerInstance, error)
+	FindContainerInstancesMissingFromECS() ([]ContainerInstance, error)
+	ComputeRemaining(instanceARN string) (int, int, error)
+	RemainingPortCount(instanceARN string) (freeTCP, freeUDP int, err error)
+	InstanceStatusCounts() (map[string]int, error)
+	AgentConnectionCounts() (int, int, error)
+
+	// Tasks
+	ListTasks(options ...ListOptions) ([]Task, error)
+	FindTasksByCluster(clusterARN string) ([]Task, error)
+	FindTasksByService(name string) ([]Task, error)
+	FindTasksByTaskSet(id string) ([]Task, error)
+	FindTasksByTaskDefinition(td string) ([]Task, error)
+	FindTasksByTag(key string, value string) ([]Task, error)
+	FindOrphanedTasks() ([]Task, error)
+	FindUnhealthyTasks() ([]Task, error)
+	FindRecentlyStoppedTasks(within time.Duration) ([]Task, error)
+	FindTasksDesiredStopped() ([]Task, error)
+	TaskDefinitionRolloutCounts() (map[string]RolloutCount, error)
+	TaskHistoryBetween(start time.Time, end time.Time) ([]TaskHistory, error)
+	WaitForTaskStatus(ctx context.Context, arn string, status string, poll time.Duration) error
+
+	// Task Definitions
+	FindTaskDefinition(td string) (TaskDefinition, error)
+	FindTaskDefinitionFamily(family string) (TaskDefinition, error)
+	FindTaskDefinitions(tds []string) (map[string]TaskDefinition, error)
+	ListCachedTaskDefinitions() []TaskDefinition
+	FindContainerRequirements(td string) ([]ContainerDefinition, error)
+
+	// Placement
+	FindLocationsForTaskDefinition(td string) *[]ContainerInstance
+	FindLocationsForTaskDefinitionWithContext(ctx context.Context, td string) (*[]ContainerInstance, error)
+	FindLocationsForTaskDefinitionWithOptions(td string, options PlacementOptions) *[]ContainerInstance
+	FindLocationsForTaskDefinitionWithFilter(td string, filter func(*gorm.DB) *gorm.DB) *[]ContainerInstance
+	FindLocationsForTaskDefinitionWithExpression(td string, expression string) (*[]ContainerInstance, error)
+	FindLocationsForTaskDefinitions(tds []string) ([]ContainerInstance, error)
+	FindLocationsWithoutTaskDefinition(td string) ([]ContainerInstance, error)
+	BestInstanceForTaskDefinition(td string, options PlacementOptions) (ContainerInstance, error)
+	ExplainPlacement(td string) (PlacementExplanation, error)
+	ContainerShortfalls(td string) ([]string, error)
+	LargestPlaceable() (int, int, error)
+	SimulatePlacements(requests map[string]int) (PlacementResult, error)
+}
+
+// Ensure a State can always be used as StateOps.
+var _ StateOps = (*State)(nil)
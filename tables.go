@@ -0,0 +1,42 @@
+package ecs_state
+
+import "github.com/jinzhu/gorm"
+
+// The gorm setting holding the Options.TablePrefix of a State's database, for code given only the database.
+const tablePrefixSetting = "ecs_state:table_prefix"
+
+// A model stored in a table of its own, named before any Options.TablePrefix.
+type tabler interface {
+	TableName() string
+}
+
+// The table a model is stored in, including the configured Options.TablePrefix, for use in raw SQL.
+func (state *State) tableName(model tabler) string {
+	return state.options.TablePrefix + model.TableName()
+}
+
+// The Options.TablePrefix of the State a database belongs to, as recorded by prefixTables.
+func tablePrefix(db *gorm.DB) string {
+	prefix, _ := db.Get(tablePrefixSetting)
+	value, _ := prefix.(string)
+	return value
+}
+
+// Has every query, create, update, and delete made through db use the table of its model with prefix prepended, and
+// records the prefix for tablePrefix.  The callbacks belong to db alone, so States with different prefixes can share
+// a process.  Schema changes such as AutoMigrate and AddIndex do not run callbacks and must name their table, and
+// conditions must be written as strings, since gorm qualifies struct conditions with the unprefixed table name.
+func prefixTables(db *gorm.DB, prefix string) *gorm.DB {
+	if len(prefix) == 0 {
+		return db
+	}
+	prefixTable := func(scope *gorm.Scope) {
+		scope.Search.Table(prefix + scope.TableName())
+	}
+	db.Callback().Create().Before("gorm:begin_transaction").Register("ecs_state:table_prefix", prefixTable)
+	db.Callback().Update().Before("gorm:begin_transaction").Register("ecs_state:table_prefix", prefixTable)
+	db.Callback().Delete().Before("gorm:begin_transaction").Register("ecs_state:table_prefix", prefixTable)
+	db.Callback().Query().Before("gorm:query").Register("ecs_state:table_prefix", prefixTable)
+	db.Callback().RowQuery().Before("gorm:row_query").Register("ecs_state:table_prefix", prefixTable)
+	return db.Set(tablePrefixSetting, prefix)
+}
@@ -0,0 +1,169 @@
+package ecs_state_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+)
+
+func TestTablePrefix(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 1024, 1024), containerInstance("b", 128, 128))
+	expectTaskDefinitions(client, taskDefinition("web", 256, 256))
+	state := newTestState(t, client, ecs_state.Options{TablePrefix: "ecsstate_"})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"ecsstate_clusters", "ecsstate_container_instances", "ecsstate_tasks", "ecsstate_task_definitions"} {
+		if !state.DB().HasTable(table) {
+			t.Errorf("expected table %s to exist", table)
+		}
+	}
+	for _, table := range []string{"clusters", "container_instances", "tasks", "task_definitions"} {
+		if state.DB().HasTable(table) {
+			t.Errorf("expected no unprefixed table %s", table)
+		}
+	}
+
+	if arns := instanceARNs(*state.FindLocationsForTaskDefinition("web:1")); len(arns) != 1 {
+		t.Errorf("expected 1 location, found %v", arns)
+	}
+	locations, err := state.FindLocationsForTaskDefinitionWithExpression("web:1", "ec2InstanceId == i-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*locations) != 1 || (*locations)[0].EC2InstanceId != "i-a" {
+		t.Errorf("expected i-a from the expression, found %v", instanceARNs(*locations))
+	}
+	idle, err := state.FindIdleInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idle) != 2 {
+		t.Errorf("expected 2 idle instances, found %d", len(idle))
+	}
+	counts, err := state.InstanceStatusCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["ACTIVE"] != 2 {
+		t.Errorf("expected 2 ACTIVE instances, found %v", counts)
+	}
+	cluster, err := state.FindClusterByNameE(testClusterName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cluster.ContainerInstances) != 2 {
+		t.Errorf("expected the cluster to preload 2 instances, found %d", len(cluster.ContainerInstances))
+	}
+
+	readOnly, err := state.ReadOnlyDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	containerInstances := []ecs_state.ContainerInstance{}
+	if err := readOnly.Find(&containerInstances).Error; err != nil || len(containerInstances) != 2 {
+		t.Errorf("expected 2 instances through ReadOnlyDB, found %d: %v", len(containerInstances), err)
+	}
+}
+
+func TestCloseReleasesDatabase(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 1024, 1024))
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.ReadOnlyDB(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := state.Close(); err != nil {
+		t.Fatal(err)
+	}
+	containerInstances := []ecs_state.ContainerInstance{}
+	if err := state.DB().Find(&containerInstances).Error; err == nil {
+		t.Errorf("queried %d ContainerInstances after Close, want an error", len(containerInstances))
+	}
+	if err := state.Healthy(); err == nil {
+		t.Error("Healthy after Close, want an error")
+	}
+}
+
+func TestReadOnlyDBRejectsWrites(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 1024, 1024))
+	state := newTestState(t, client, ecs_state.Options{TablePrefix: "ro_"})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+	readOnly, err := state.ReadOnlyDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, write := range map[string]func() error{
+		"create": func() error { return readOnly.Create(&ecs_state.ContainerInstance{ARN: "created"}).Error },
+		"update": func() error {
+			return readOnly.Model(&ecs_state.ContainerInstance{}).UpdateColumn("remaining_cpu", 0).Error
+		},
+		"delete": func() error { return readOnly.Delete(&ecs_state.ContainerInstance{}).Error },
+		"pragma": func() error { return readOnly.Exec("PRAGMA query_only = OFF").Error },
+		"raw query": func() error {
+			return readOnly.Raw("DELETE FROM ro_container_instances").Scan(&[]ecs_state.ContainerInstance{}).Error
+		},
+		"raw row": func() error { return readOnly.Raw("DELETE FROM ro_container_instances").Row().Scan() },
+		"multiple statements": func() error {
+			return readOnly.Raw("SELECT 1; DELETE FROM ro_container_instances").Scan(&[]ecs_state.ContainerInstance{}).Error
+		},
+	} {
+		if err := write(); !errors.Is(err, ecs_state.ErrReadOnlyDatabase) {
+			t.Errorf("%s through ReadOnlyDB returned %v, want ErrReadOnlyDatabase", name, err)
+		}
+	}
+
+	containerInstances := []ecs_state.ContainerInstance{}
+	if err := readOnly.Find(&containerInstances).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(containerInstances) != 1 || containerInstances[0].RemainingCPU != 1024 {
+		t.Errorf("found %+v after writes through ReadOnlyDB, want the refreshed instance unchanged", containerInstances)
+	}
+}
+
+func TestReadOnlyDBWaitsForTransactions(t *testing.T) {
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 1024, 1024))
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+	readOnly, err := state.ReadOnlyDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := state.DB().Begin()
+	if err := tx.Create(&ecs_state.ContainerInstance{ARN: "uncommitted"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	counted := make(chan int, 1)
+	go func() {
+		count := 0
+		readOnly.Model(&ecs_state.ContainerInstance{}).Count(&count)
+		counted <- count
+	}()
+	select {
+	case count := <-counted:
+		t.Fatalf("counted %d ContainerInstances during an open transaction, want the query to wait", count)
+	case <-time.After(50 * time.Millisecond):
+	}
+	tx.Rollback()
+	if count := <-counted; count != 1 {
+		t.Errorf("counted %d ContainerInstances after the rollback, want 1", count)
+	}
+}
@@ -0,0 +1,15 @@
+package ecs_state
+
+// Local representation of a resource tag on an ECS Task or ContainerInstance and stored by gorm.  Tags are only
+// requested from ECS, and so only stored, when Options.IncludeTags is set.
+type Tag struct {
+	ID          int    `gorm:"primary_key"`
+	ResourceARN string `sql:"size:1024;index"`
+	Key         string `sql:"size:1024;index"`
+	Value       string `sql:"size:1024"`
+}
+
+// The table Tags are stored in, before any Options.TablePrefix.
+func (Tag) TableName() string {
+	return "tags"
+}
@@ -2,15 +2,62 @@ package ecs_state
 
 // Local representation of an ECS Task and stored by gorm.  A number of fields are absent
 // for now as they are not needed to track and update the state of the state of the cluster typically.
+// OverrideCpu and OverrideMemory hold any task level resource overrides the task was launched with, or zero, and
+// ContainerOverrides any container level ones.  TCPPorts and UDPPorts list the host ports its containers are bound to,
+// dynamic ones included, in the searchable format of the ContainerInstance port columns.
+// StartedAt and StoppedAt are the Unix times the task started and stopped, or zero if it has not.  Group is "service:<name>" for tasks started by a service.
 type Task struct {
 	ARN                  string `sql:"size:1024" gorm:"primary_key"`
 	DesiredStatus        string
 	LastStatus           string
 	StartedBy            string `sql:"index"`
+	Group                string `sql:"index"`
 	ClusterARN           string `sql:"size:1024;index"`
 	ContainerInstanceARN string `sql:"size:1024;index"`
 	TaskDefinitionARN    string `sql:"size:1024;index"`
+	OverrideCpu          int
+	OverrideMemory       int
+	ContainerOverrides   []ContainerOverride
+	TCPPorts             string `sql:"size:1024" gorm:"column:tcp_ports"`
+	UDPPorts             string `sql:"size:1024" gorm:"column:udp_ports"`
+	Connectivity         string
+	HealthStatus         string `sql:"index"`
+	StoppedReason        string `sql:"size:1024"`
+	StopCode             string
+	StartedAt            int
+	StoppedAt            int
 
 	// Not part of the ECS API
 	RefreshTime int
 }
+
+// The table Tasks are stored in, before any Options.TablePrefix.
+func (Task) TableName() string {
+	return "tasks"
+}
+
+// The CPU and memory this Task actually consumes, applying its overrides to its TaskDefinition, whose
+// ContainerDefinitions must be loaded for container overrides to apply.
+func (task Task) ResourceRequirements(taskDefinition TaskDefinition) (cpu, memory int) {
+	cpu, memory = taskDefinition.Cpu, taskDefinition.Memory
+	for _, override := range task.ContainerOverrides {
+		for _, container := range taskDefinition.ContainerDefinitions {
+			if container.Name != override.Name {
+				continue
+			}
+			if override.Cpu > 0 && taskDefinition.TaskCpu == 0 {
+				cpu += override.Cpu - container.Cpu
+			}
+			if override.Memory > 0 && taskDefinition.TaskMemory == 0 {
+				memory += override.Memory - container.Memory
+			}
+		}
+	}
+	if task.OverrideCpu > 0 {
+		cpu = task.OverrideCpu
+	}
+	if task.OverrideMemory > 0 {
+		memory = task.OverrideMemory
+	}
+	return cpu, memory
+}
@@ -1,12 +1,32 @@
 package ecs_state
 
 // Local representation of an ECS TaskDefinition and stored by gorm.  Resources are extracted,
-// but the complete definition is ignored.
+// but the complete definition is ignored.  Memory is the amount required for placement, using each
+// container's hard limit when set and its soft limit otherwise, while MemoryReservation totals the soft limits.
+// EssentialCpu and EssentialMemory only count containers marked essential.  TaskCpu and TaskMemory are the task level
+// resources of the definition, as required by Fargate, or zero if it only sets them per container.  DynamicTCPPorts and DynamicUDPPorts count the
+// port mappings bridge networking binds to a host port chosen from the dynamic port range.
 type TaskDefinition struct {
-	ARN         string `sql:"size:1024" gorm:"primary_key"`
-	ShortString string `sql:"unique"`
-	Cpu         int
-	Memory      int
-	TCPPorts    string
-	UDPPorts    string
+	ARN                  string `sql:"size:1024" gorm:"primary_key"`
+	ShortString          string `sql:"unique"`
+	Cpu                  int
+	Memory               int
+	MemoryReservation    int
+	EssentialCpu         int
+	EssentialMemory      int
+	TaskCpu              int
+	TaskMemory           int
+	TCPPorts             string
+	UDPPorts             string
+	DynamicTCPPorts      int
+	DynamicUDPPorts      int
+	ContainerDefinitions []ContainerDefinition
+
+	// Not part of the ECS API
+	RefreshTime int
+}
+
+// The table TaskDefinitions are stored in, before any Options.TablePrefix.
+func (TaskDefinition) TableName() string {
+	return "task_definitions"
 }
@@ -0,0 +1,16 @@
+package ecs_state
+
+// Records the revision a bare family name, such as my_app, last resolved to when described from ECS, so that
+// family lookups can be served from the local cache until Options.FamilyAliasTTL passes.
+type TaskDefinitionAlias struct {
+	Family            string `sql:"size:255" gorm:"primary_key"`
+	TaskDefinitionARN string `sql:"size:1024"`
+
+	// Not part of the ECS API
+	RefreshTime int
+}
+
+// The table TaskDefinitionAliases are stored in, before any Options.TablePrefix.
+func (TaskDefinitionAlias) TableName() string {
+	return "task_definition_aliases"
+}
@@ -0,0 +1,112 @@
+package ecs_state_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestFindTaskDefinitionDuplicateHostPort(t *testing.T) {
+	definition := taskDefinition("web", 256, 256, portMapping(8080, "tcp"))
+	definition.ContainerDefinitions = append(definition.ContainerDefinitions, &ecs.ContainerDefinition{
+		Name:         aws.String("sidecar"),
+		Cpu:          aws.Int64(128),
+		Memory:       aws.Int64(128),
+		PortMappings: []*ecs.PortMapping{portMapping(8080, "tcp"), portMapping(8080, "udp")},
+	})
+	client := mocks.NewECSAPI(t)
+	expectTaskDefinitions(client, definition)
+	state := newTestState(t, client, ecs_state.Options{})
+
+	taskDefinition, err := state.FindTaskDefinition("web:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second TCP binding of 8080 is dropped, while UDP 8080 is a different port
+	if taskDefinition.TCPPorts != "8080" || taskDefinition.UDPPorts != "8080" {
+		t.Errorf("kept TCP ports %q and UDP ports %q, want 8080 once each", taskDefinition.TCPPorts, taskDefinition.UDPPorts)
+	}
+	if taskDefinition.Cpu != 384 || taskDefinition.Memory != 384 {
+		t.Errorf("summed %d CPU and %d memory, want both containers' 384", taskDefinition.Cpu, taskDefinition.Memory)
+	}
+}
+
+func TestFindTaskDefinitionTaskLevelResources(t *testing.T) {
+	taskLevel := taskDefinition("fargate", 0, 0)
+	taskLevel.Cpu, taskLevel.Memory = aws.String("1 vCPU"), aws.String("2048")
+	client := mocks.NewECSAPI(t)
+	expectTaskDefinitions(client, taskLevel, taskDefinition("ec2", 256, 512))
+	state := newTestState(t, client, ecs_state.Options{})
+
+	for td, want := range map[string][2]int{
+		"fargate:1": {1024, 2048},
+		"ec2:1":     {256, 512},
+	} {
+		taskDefinition, err := state.FindTaskDefinition(td)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taskDefinition.Cpu != want[0] || taskDefinition.Memory != want[1] {
+			t.Errorf("%s requires %d CPU and %d memory, want %d and %d", td, taskDefinition.Cpu, taskDefinition.Memory, want[0], want[1])
+		}
+	}
+}
+
+func TestFindTaskDefinitionMemoryReservation(t *testing.T) {
+	definition := taskDefinition("web", 256, 512)
+	definition.ContainerDefinitions = append(definition.ContainerDefinitions, &ecs.ContainerDefinition{
+		Name:              aws.String("soft"),
+		Cpu:               aws.Int64(128),
+		MemoryReservation: aws.Int64(256),
+	})
+	client := mocks.NewECSAPI(t)
+	expectTaskDefinitions(client, definition)
+	state := newTestState(t, client, ecs_state.Options{})
+
+	taskDefinition, err := state.FindTaskDefinition("web:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The container with only a soft limit is placed by it, rather than requiring no memory at all
+	if taskDefinition.Memory != 768 {
+		t.Errorf("requires %d memory, want 768", taskDefinition.Memory)
+	}
+	if taskDefinition.MemoryReservation != 256 {
+		t.Errorf("reserves %d memory, want 256", taskDefinition.MemoryReservation)
+	}
+}
+
+func TestFindTaskDefinitionNilFields(t *testing.T) {
+	for name, test := range map[string]struct {
+		edit        func(*ecs.TaskDefinition)
+		err         error
+		shortString string
+	}{
+		"nil ARN":          {func(d *ecs.TaskDefinition) { d.TaskDefinitionArn = nil }, ecs_state.ErrTaskDefinitionNotFound, ""},
+		"nil family":       {func(d *ecs.TaskDefinition) { d.Family = nil }, nil, ":1"},
+		"nil revision":     {func(d *ecs.TaskDefinition) { d.Revision = nil }, nil, "web:0"},
+		"nil container":    {func(d *ecs.TaskDefinition) { d.ContainerDefinitions = append(d.ContainerDefinitions, nil) }, nil, "web:1"},
+		"nil port mapping": {func(d *ecs.TaskDefinition) { d.ContainerDefinitions[0].PortMappings[0] = nil }, nil, "web:1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			definition := taskDefinition("web", 256, 256, portMapping(8080, "tcp"))
+			test.edit(definition)
+			client := mocks.NewECSAPI(t)
+			client.On("DescribeTaskDefinition", mock.Anything).Return(&ecs.DescribeTaskDefinitionOutput{TaskDefinition: definition}, nil)
+			state := newTestState(t, client, ecs_state.Options{})
+
+			taskDefinition, err := state.FindTaskDefinition("arn:aws:ecs:us-east-1:123456789012:task-definition/web:1")
+			if !errors.Is(err, test.err) {
+				t.Fatalf("returned %v, want %v", err, test.err)
+			}
+			if err == nil && (taskDefinition.ShortString != test.shortString || taskDefinition.Cpu != 256) {
+				t.Errorf("stored %+v, want %s with 256 CPU", taskDefinition, test.shortString)
+			}
+		})
+	}
+}
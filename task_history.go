@@ -0,0 +1,69 @@
+package ecs_state
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The final state of a Task as it was removed from local state, recorded when Options.TaskHistory is set so that
+// what ran remains queryable, for example for auditing or billing.  Times are Unix times, with StartedAt and StoppedAt
+// zero if ECS never reported them, and PrunedAt the time the Task was removed.
+type TaskHistory struct {
+	ID                   int    `gorm:"primary_key"`
+	TaskARN              string `sql:"size:1024;index"`
+	ClusterARN           string `sql:"size:1024"`
+	ContainerInstanceARN string `sql:"size:1024"`
+	TaskDefinitionARN    string `sql:"size:1024;index"`
+	Group                string
+	LastStatus           string
+	StartedAt            int
+	StoppedAt            int    `sql:"index"`
+	StoppedReason        string `sql:"size:1024"`
+	StopCode             string
+	PrunedAt             int `sql:"index"`
+}
+
+// The table TaskHistory is stored in, before any Options.TablePrefix.
+func (TaskHistory) TableName() string {
+	return "task_history"
+}
+
+// Returns the recorded history of Tasks that stopped between start and end inclusive, oldest first.  Tasks whose
+// stop time ECS never reported are matched by when they were removed from local state instead.
+func (state *State) TaskHistoryBetween(start, end time.Time) ([]TaskHistory, error) {
+	state.log.Info("entering TaskHistoryBetween()")
+	history := []TaskHistory{}
+	err := state.DB().Where("(CASE WHEN stopped_at > 0 THEN stopped_at ELSE pruned_at END) BETWEEN ? AND ?", start.Unix(), end.Unix()).
+		Order("stopped_at, id").Find(&history).Error
+	return history, err
+}
+
+// Records the final state of a Task about to be removed from local state.  Does nothing unless Options.TaskHistory is set.
+func (state *State) recordTaskHistory(db *gorm.DB, task Task, prunedAt int) error {
+	if !state.options.TaskHistory {
+		return nil
+	}
+	return db.Create(&TaskHistory{
+		TaskARN:              task.ARN,
+		ClusterARN:           task.ClusterARN,
+		ContainerInstanceARN: task.ContainerInstanceARN,
+		TaskDefinitionARN:    task.TaskDefinitionARN,
+		Group:                task.Group,
+		LastStatus:           task.LastStatus,
+		StartedAt:            task.StartedAt,
+		StoppedAt:            task.StoppedAt,
+		StoppedReason:        task.StoppedReason,
+		StopCode:             task.StopCode,
+		PrunedAt:             prunedAt,
+	}).Error
+}
+
+// Removes recorded history older than Options.TaskHistoryRetention, if set.
+func (state *State) trimTaskHistory(db *gorm.DB) error {
+	if !state.options.TaskHistory || state.options.TaskHistoryRetention <= 0 {
+		return nil
+	}
+	cutoff := int(state.now().Add(-state.options.TaskHistoryRetention).Unix())
+	return db.Where("pruned_at < ?", cutoff).Delete(TaskHistory{}).Error
+}
@@ -0,0 +1,70 @@
+package ecs_state_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+)
+
+// A Clock reporting a time set by the test.
+type testClock struct {
+	now time.Time
+}
+
+func (clock *testClock) Now() time.Time {
+	return clock.now
+}
+
+func TestTaskHistoryRetention(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clock := &testClock{now: start}
+	client := mocks.NewECSAPI(t)
+	expectTasksWithStatus(client, "", task("a", "web", "a"), task("b", "web", "a"))
+	expectTasksWithStatus(client, "", task("b", "web", "a"))
+	expectTasksWithStatus(client, "", task("c", "web", "a"))
+	state := newTestState(t, client, ecs_state.Options{TaskHistory: true, TaskHistoryRetention: time.Hour, Clock: clock})
+
+	// a is pruned a minute in, then b two hours in, by which time a's history has outlived the retention
+	for _, elapsed := range []time.Duration{0, time.Minute, 2 * time.Hour} {
+		clock.now = start.Add(elapsed)
+		if err := state.RefreshTaskState(); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed == time.Minute {
+			history, err := state.TaskHistoryBetween(start, clock.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) != 1 || history[0].TaskARN != *task("a", "web", "a").TaskArn || history[0].LastStatus != "RUNNING" {
+				t.Errorf("recorded %+v after a was pruned, want a's final state", history)
+			}
+		}
+	}
+
+	history, err := state.TaskHistoryBetween(start, clock.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].TaskARN != *task("b", "web", "a").TaskArn || history[0].PrunedAt != int(clock.now.Unix()) {
+		t.Errorf("kept %+v, want only b pruned at %d", history, clock.now.Unix())
+	}
+
+	// History survives a snapshot round trip, replacing any the importer recorded
+	snapshot := bytes.Buffer{}
+	if err := state.ExportSnapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.ImportSnapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := state.TaskHistoryBetween(start, clock.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 1 || imported[0] != history[0] {
+		t.Errorf("imported %+v, want %+v", imported, history)
+	}
+}
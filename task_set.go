@@ -0,0 +1,25 @@
+package ecs_state
+
+// Local representation of a task set of an ECS service using the CODE_DEPLOY or EXTERNAL deployment controller, as
+// used for blue/green deployments, and stored by gorm.  Status is PRIMARY for the task set receiving production
+// traffic, ACTIVE for others such as a canary, and DRAINING for one being removed.  Tasks in a task set carry its ID
+// as their StartedBy, which FindTasksByTaskSet uses to link them.  CreatedAtUnix is when ECS created the task set as a
+// Unix time, named so that gorm does not mistake it for its own CreatedAt timestamp.
+type TaskSet struct {
+	ID                   string `sql:"size:1024" gorm:"primary_key"`
+	ARN                  string `sql:"size:1024"`
+	ServiceARN           string `sql:"size:1024;index"`
+	Status               string
+	TaskDefinitionARN    string `sql:"size:1024"`
+	ExternalID           string
+	ComputedDesiredCount int
+	RunningCount         int
+	PendingCount         int
+	StabilityStatus      string
+	CreatedAtUnix        int
+}
+
+// The table TaskSets are stored in, before any Options.TablePrefix.
+func (TaskSet) TableName() string {
+	return "task_sets"
+}
@@ -0,0 +1,271 @@
+package ecs_state_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestComputeRemainingHonorsOverrides(t *testing.T) {
+	taskOverride := task("task-override", "web", "a")
+	taskOverride.Overrides = &ecs.TaskOverride{Memory: aws.String("1024")}
+	containerOverride := task("container-override", "web", "a")
+	containerOverride.Overrides = &ecs.TaskOverride{ContainerOverrides: []*ecs.ContainerOverride{
+		{Name: aws.String("web"), Cpu: aws.Int64(512), Memory: aws.Int64(2048)},
+	}}
+	fargate := taskDefinition("batch", 256, 256)
+	fargate.Cpu, fargate.Memory = aws.String("1024"), aws.String("1024")
+	ignoredOverride := task("ignored-override", "batch", "a")
+	ignoredOverride.Overrides = &ecs.TaskOverride{ContainerOverrides: []*ecs.ContainerOverride{
+		{Name: aws.String("batch"), Memory: aws.Int64(3072)},
+	}}
+
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 0, 0))
+	expectTasks(client, taskOverride, containerOverride, ignoredOverride)
+	expectTaskDefinitions(client, taskDefinition("web", 256, 512), fargate)
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	cpu, memory, err := state.ComputeRemaining("arn:aws:ecs:us-east-1:123456789012:container-instance/test/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The task override replaces the definition's 512 memory, the container override the container's 256 CPU and
+	// 512 memory, and the task level resources of the Fargate definition are reserved whatever its containers want.
+	if wantCPU := 4096 - 256 - 512 - 1024; cpu != wantCPU {
+		t.Errorf("computed %d CPU remaining, want %d", cpu, wantCPU)
+	}
+	if wantMemory := 4096 - 1024 - 2048 - 1024; memory != wantMemory {
+		t.Errorf("computed %d memory remaining, want %d", memory, wantMemory)
+	}
+}
+
+func TestComputeRemainingCountsNonEssentialContainers(t *testing.T) {
+	sidecar := taskDefinition("web", 256, 512)
+	sidecar.ContainerDefinitions = append(sidecar.ContainerDefinitions, &ecs.ContainerDefinition{
+		Name: aws.String("logs"), Cpu: aws.Int64(128), Memory: aws.Int64(256), Essential: aws.Bool(false),
+	})
+
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 0, 0))
+	expectTasks(client, task("web", "web", "a"))
+	expectTaskDefinitions(client, sidecar)
+	state := newTestState(t, client, ecs_state.Options{ExcludeNonEssentialContainers: true})
+	if err := state.RefreshAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	taskDefinition, err := state.FindTaskDefinition("web:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taskDefinition.Cpu != 256 || taskDefinition.Memory != 512 {
+		t.Errorf("placement requires %d CPU and %d memory, want only the essential 256 and 512", taskDefinition.Cpu, taskDefinition.Memory)
+	}
+	cpu, memory, err := state.ComputeRemaining("arn:aws:ecs:us-east-1:123456789012:container-instance/test/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpu != 4096-384 || memory != 4096-768 {
+		t.Errorf("computed %d CPU and %d memory remaining, want %d and %d", cpu, memory, 4096-384, 4096-768)
+	}
+}
+
+func TestRefreshTaskStateListsStoppedTasksWhenRetained(t *testing.T) {
+	running := task("running", "web", "a")
+	stopped := task("stopped", "web", "a")
+	stopped.DesiredStatus, stopped.LastStatus = aws.String(ecs.DesiredStatusStopped), aws.String(ecs.DesiredStatusStopped)
+	stopped.StoppedReason = aws.String("Essential container in task exited")
+	stopped.StoppedAt = aws.Time(time.Now())
+
+	client := mocks.NewECSAPI(t)
+	expectTasksWithStatus(client, "", running)
+	expectTasksWithStatus(client, ecs.DesiredStatusStopped, stopped)
+	state := newTestState(t, client, ecs_state.Options{StoppedTaskRetention: time.Hour})
+	if err := state.RefreshTaskState(); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks, err := state.FindRecentlyStoppedTasks(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].ARN != *stopped.TaskArn {
+		t.Fatalf("found %+v recently stopped, want only %s", tasks, *stopped.TaskArn)
+	}
+	if tasks[0].StoppedReason != *stopped.StoppedReason {
+		t.Errorf("kept StoppedReason %q, want %q", tasks[0].StoppedReason, *stopped.StoppedReason)
+	}
+}
+
+func TestRefreshTaskStateWithStatus(t *testing.T) {
+	running := task("running", "web", "a")
+	stopped := task("stopped", "web", "a")
+	stopped.DesiredStatus, stopped.LastStatus = aws.String(ecs.DesiredStatusStopped), aws.String(ecs.DesiredStatusStopped)
+
+	client := mocks.NewECSAPI(t)
+	expectTasksWithStatus(client, ecs.DesiredStatusStopped, stopped)
+	expectTasksWithStatus(client, ecs.DesiredStatusRunning, running)
+	expectTasksWithStatus(client, "", running)
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshTaskStateWithStatus(ecs.DesiredStatusStopped); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.RefreshTaskStateWithStatus(ecs.DesiredStatusRunning); err != nil {
+		t.Fatal(err)
+	}
+
+	// The STOPPED Task is outside the RUNNING refresh, so it is not swept for being missing from it
+	tasks := []ecs_state.Task{}
+	if err := state.DB().Order("a_r_n").Find(&tasks).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("kept %d Tasks after the filtered refreshes, want 2", len(tasks))
+	}
+	if refreshed, err := state.LastRefresh(ecs_state.RefreshKindTasks); err != nil || !refreshed.IsZero() {
+		t.Errorf("filtered refreshes recorded a Task refresh at %v (%v), want none", refreshed, err)
+	}
+
+	if err := state.RefreshTaskState(); err != nil {
+		t.Fatal(err)
+	}
+	if refreshed, err := state.LastRefresh(ecs_state.RefreshKindTasks); err != nil || refreshed.IsZero() {
+		t.Errorf("unfiltered refresh was not recorded (%v)", err)
+	}
+}
+
+func TestRefreshTaskStateUnplacedTask(t *testing.T) {
+	pending := &ecs.Task{
+		TaskArn:           aws.String("arn:aws:ecs:us-east-1:123456789012:task/test/pending"),
+		ClusterArn:        aws.String(testClusterARN),
+		TaskDefinitionArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/web:1"),
+		DesiredStatus:     aws.String(ecs.DesiredStatusRunning),
+	}
+
+	client := mocks.NewECSAPI(t)
+	expectTasks(client, pending, task("running", "web", "a"))
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshTaskState(); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := ecs_state.Task{}
+	if err := state.DB().Where("a_r_n = ?", *pending.TaskArn).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.ContainerInstanceARN != "" || stored.StartedBy != "" || stored.LastStatus != "" {
+		t.Errorf("stored %+v, want no ContainerInstanceARN, StartedBy or LastStatus", stored)
+	}
+	if stored.DesiredStatus != ecs.DesiredStatusRunning || stored.TaskDefinitionARN != *pending.TaskDefinitionArn {
+		t.Errorf("stored %+v, want the fields that were present", stored)
+	}
+}
+
+func TestFindTasksByCluster(t *testing.T) {
+	otherClusterARN := "arn:aws:ecs:us-east-1:123456789012:cluster/other"
+	other := task("other", "web", "b")
+	other.ClusterArn = aws.String(otherClusterARN)
+
+	client := mocks.NewECSAPI(t)
+	expectTasks(client, task("web", "web", "a"), other)
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshTaskState(); err != nil {
+		t.Fatal(err)
+	}
+
+	for clusterARN, want := range map[string]string{testClusterARN: "web", otherClusterARN: "other"} {
+		tasks, err := state.FindTasksByCluster(clusterARN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tasks) != 1 || tasks[0].ARN != *task(want, "web", "a").TaskArn {
+			t.Errorf("found %+v in %s, want only the %s task", tasks, clusterARN, want)
+		}
+	}
+	if tasks, err := state.FindTasksByCluster("arn:aws:ecs:us-east-1:123456789012:cluster/missing"); err != nil || len(tasks) != 0 {
+		t.Errorf("found %+v (%v) in an unknown cluster, want none", tasks, err)
+	}
+}
+
+func TestWaitForTaskStatus(t *testing.T) {
+	pending := task("web", "web", "a")
+	pending.LastStatus = aws.String("PENDING")
+	stopped := task("web", "web", "a")
+	stopped.LastStatus = aws.String(ecs.DesiredStatusStopped)
+	missing := &ecs.DescribeTasksOutput{Failures: []*ecs.Failure{{Arn: task("web", "web", "a").TaskArn, Reason: aws.String("MISSING")}}}
+
+	for name, test := range map[string]struct {
+		responses []*ecs.DescribeTasksOutput
+		status    string
+		err       error
+	}{
+		"reaches status": {[]*ecs.DescribeTasksOutput{{Tasks: []*ecs.Task{pending}}, {Tasks: []*ecs.Task{task("web", "web", "a")}}}, ecs.DesiredStatusRunning, nil},
+		"stops":          {[]*ecs.DescribeTasksOutput{{Tasks: []*ecs.Task{stopped}}}, ecs.DesiredStatusRunning, ecs_state.ErrTaskStopped},
+		"disappears":     {[]*ecs.DescribeTasksOutput{missing}, ecs.DesiredStatusRunning, ecs_state.ErrTaskStopped},
+		"waits for stop": {[]*ecs.DescribeTasksOutput{missing}, ecs.DesiredStatusStopped, nil},
+		"times out":      {[]*ecs.DescribeTasksOutput{{Tasks: []*ecs.Task{pending}}}, ecs.DesiredStatusRunning, context.DeadlineExceeded},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := mocks.NewECSAPI(t)
+			// Only the waited for Task is described, so nothing is listed
+			for i, response := range test.responses {
+				call := client.On("DescribeTasks", mock.MatchedBy(func(params *ecs.DescribeTasksInput) bool {
+					return len(params.Tasks) == 1 && aws.StringValue(params.Tasks[0]) == aws.StringValue(pending.TaskArn)
+				})).Return(response, nil)
+				if i < len(test.responses)-1 {
+					call.Once()
+				}
+			}
+			// DryRun never stores the Task, which the wait does not depend on
+			state := newTestState(t, client, ecs_state.Options{DryRun: true})
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			if err := state.WaitForTaskStatus(ctx, aws.StringValue(pending.TaskArn), test.status, time.Millisecond); !errors.Is(err, test.err) {
+				t.Errorf("returned %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestTaskDefinitionRolloutCounts(t *testing.T) {
+	pending := task("pending", "web", "a")
+	pending.LastStatus = aws.String("PENDING")
+	stopping := task("stopping", "api", "a")
+	stopping.DesiredStatus = aws.String(ecs.DesiredStatusStopped)
+	stopped := task("stopped", "api", "a")
+	stopped.DesiredStatus, stopped.LastStatus = aws.String(ecs.DesiredStatusStopped), aws.String(ecs.DesiredStatusStopped)
+	batch := task("batch", "batch", "a")
+	batch.DesiredStatus, batch.LastStatus = aws.String(ecs.DesiredStatusStopped), aws.String(ecs.DesiredStatusStopped)
+
+	client := mocks.NewECSAPI(t)
+	expectTasks(client, task("running", "web", "a"), pending, task("api", "api", "a"), stopping, stopped, batch)
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshTaskState(); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := state.TaskDefinitionRolloutCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]ecs_state.RolloutCount{
+		*pending.TaskDefinitionArn:  {Desired: 2, Running: 1},
+		*stopping.TaskDefinitionArn: {Desired: 1, Running: 2},
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("counted %+v, want %+v", counts, want)
+	}
+}
@@ -0,0 +1,68 @@
+package ecs_state
+
+import "fmt"
+
+// The kinds of Inconsistency reported by Validate.
+const (
+	InconsistencyUnknownContainerInstance = "unknown_container_instance"
+	InconsistencyUnknownCluster           = "unknown_cluster"
+	InconsistencyNegativeResources        = "negative_resources"
+)
+
+// A problem found in the local state by Validate, naming the ARN of the offending Task or ContainerInstance.
+type Inconsistency struct {
+	Kind        string
+	ARN         string
+	Description string
+}
+
+// Checks the local state for inconsistencies that suggest refreshes ran out of order or failed partway: Tasks on a
+// ContainerInstance or in a Cluster that is not present locally, and ContainerInstances with negative remaining CPU
+// or memory.  Every finding is returned, and the error is only set if the checks themselves fail.
+func (state *State) Validate() ([]Inconsistency, error) {
+	state.log.Info("entering Validate()")
+	inconsistencies := []Inconsistency{}
+	tasks := state.tableName(Task{})
+
+	orphaned, err := state.FindOrphanedTasks()
+	if err != nil {
+		return inconsistencies, err
+	}
+	for _, task := range orphaned {
+		inconsistencies = append(inconsistencies, Inconsistency{
+			Kind:        InconsistencyUnknownContainerInstance,
+			ARN:         task.ARN,
+			Description: fmt.Sprintf("Task is on ContainerInstance %s which is not in local state", task.ContainerInstanceARN),
+		})
+	}
+
+	unclustered := []Task{}
+	err = state.DB().Where(fmt.Sprintf("%s.cluster_a_r_n NOT IN (SELECT a_r_n FROM %s)", tasks, state.tableName(Cluster{}))).Find(&unclustered).Error
+	if err != nil {
+		return inconsistencies, err
+	}
+	for _, task := range unclustered {
+		inconsistencies = append(inconsistencies, Inconsistency{
+			Kind:        InconsistencyUnknownCluster,
+			ARN:         task.ARN,
+			Description: fmt.Sprintf("Task is in Cluster %s which is not in local state", task.ClusterARN),
+		})
+	}
+
+	overcommitted := []ContainerInstance{}
+	err = state.DB().Where("remaining_cpu < 0 OR remaining_memory < 0").Find(&overcommitted).Error
+	if err != nil {
+		return inconsistencies, err
+	}
+	for _, containerInstance := range overcommitted {
+		inconsistencies = append(inconsistencies, Inconsistency{
+			Kind: InconsistencyNegativeResources,
+			ARN:  containerInstance.ARN,
+			Description: fmt.Sprintf("ContainerInstance has negative remaining resources, cpu %d and memory %d",
+				containerInstance.RemainingCPU, containerInstance.RemainingMemory),
+		})
+	}
+
+	state.log.Debug(fmt.Sprintf("Found %d inconsistencies", len(inconsistencies)))
+	return inconsistencies, nil
+}
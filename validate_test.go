@@ -0,0 +1,47 @@
+package ecs_state_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/jhspaybar/ecs_state"
+	"github.com/jhspaybar/ecs_state/mocks"
+)
+
+func TestValidate(t *testing.T) {
+	otherCluster := task("other-cluster", "web", "a")
+	otherCluster.ClusterArn = aws.String("arn:aws:ecs:us-east-1:123456789012:cluster/other")
+	client := mocks.NewECSAPI(t)
+	expectContainerInstances(client, containerInstance("a", 2048, 2048), containerInstance("b", 2048, 2048))
+	expectTasks(client, task("placed", "web", "a"), task("orphaned", "web", "missing"), otherCluster)
+	state := newTestState(t, client, ecs_state.Options{})
+	if err := state.RefreshContainerInstanceState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.RefreshTaskState(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := state.DB().Model(&ecs_state.ContainerInstance{}).Where("a_r_n = ?", "arn:aws:ecs:us-east-1:123456789012:container-instance/test/b").
+		UpdateColumn("remaining_memory", -256).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	inconsistencies, err := state.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]string{}
+	for _, inconsistency := range inconsistencies {
+		found[inconsistency.ARN] = inconsistency.Kind
+	}
+	want := map[string]string{
+		*task("orphaned", "web", "missing").TaskArn:                    ecs_state.InconsistencyUnknownContainerInstance,
+		*otherCluster.TaskArn:                                          ecs_state.InconsistencyUnknownCluster,
+		"arn:aws:ecs:us-east-1:123456789012:container-instance/test/b": ecs_state.InconsistencyNegativeResources,
+	}
+	if !reflect.DeepEqual(found, want) || len(inconsistencies) != len(want) {
+		t.Errorf("found %+v, want %v", inconsistencies, want)
+	}
+}
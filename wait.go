@@ -0,0 +1,55 @@
+package ecs_state
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Blocks until the Task reaches the status, describing it in ECS every poll without touching local state.  Returns
+// ErrTaskStopped if it stops or disappears first, or the context's error once it is done.
+func (state *State) WaitForTaskStatus(ctx context.Context, arn, status string, poll time.Duration) error {
+	state.log.Info("entering WaitForTaskStatus()")
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if lastStatus, err := state.describeTaskStatus(arn); err != nil {
+			state.log.Warn("DescribeTasks failed while waiting for Task", arn, "to reach", status, err)
+		} else {
+			state.log.Debug("Task", arn, "has status", lastStatus, "waiting for", status)
+			if lastStatus == status {
+				return nil
+			}
+			if lastStatus == ecs.DesiredStatusStopped {
+				return ErrTaskStopped
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
+
+// Describes a single Task in ECS and returns its LastStatus, or STOPPED if ECS does not return it.
+func (state *State) describeTaskStatus(arn string) (string, error) {
+	resp, err := state.ecs_client.DescribeTasks(&ecs.DescribeTasksInput{
+		Cluster: aws.String(state.clusterName),
+		Tasks:   []*string{aws.String(arn)},
+	})
+	if err != nil {
+		return "", state.handleAwsError(err)
+	}
+	state.handleFailures(resp.Failures)
+	for _, task := range resp.Tasks {
+		if task != nil && aws.StringValue(task.TaskArn) == arn {
+			return aws.StringValue(task.LastStatus), nil
+		}
+	}
+	return ecs.DesiredStatusStopped, nil
+}